@@ -1,77 +1,888 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/ssotops/gitspace-plugin/gsplug"
 )
 
-const version = "1.0.0" // You can update this version number as needed
-
 func main() {
 	buildCmd := flag.NewFlagSet("build", flag.ExitOnError)
 	buildAll := buildCmd.Bool("all", false, "Build all plugins")
+	buildJobs := buildCmd.Int("j", runtime.NumCPU(), "Number of plugins to build concurrently with -all")
+	buildFailFast := buildCmd.Bool("fail-fast", false, "Stop at the first failed plugin (with -all)")
+	buildForce := buildCmd.Bool("force", false, "Rebuild plugins even if they are up to date")
+	buildRunner := addRunnerFlags(buildCmd)
+	buildConfig := addConfigFlags(buildCmd)
+
+	packageCmd := flag.NewFlagSet("package", flag.ExitOnError)
+	packageOutput := packageCmd.String("o", "", "Directory to write the package to (default: the plugin's dist directory)")
+	packageForce := packageCmd.Bool("force", false, "Rebuild the plugin even if it is up to date")
+	packageRunner := addRunnerFlags(packageCmd)
+	packageConfig := addConfigFlags(packageCmd)
+
+	inspectCmd := flag.NewFlagSet("inspect", flag.ExitOnError)
+	inspectJSON := inspectCmd.Bool("json", false, "Print the package contents as JSON")
+
+	installCmd := flag.NewFlagSet("install", flag.ExitOnError)
+	installRef := installCmd.String("ref", "", "Branch, tag or commit to install from a Git repository")
+	installForce := installCmd.Bool("force", false, "Replace an installed plugin of the same name, and install packages that fail the compatibility checks")
+	installRunner := addRunnerFlags(installCmd)
+	installConfig := addConfigFlags(installCmd)
+
+	uninstallCmd := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	uninstallConfig := addConfigFlags(uninstallCmd)
+
+	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	listJSON := listCmd.Bool("json", false, "Print the plugins as JSON")
+	listConfig := addConfigFlags(listCmd)
+
+	keygenCmd := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keygenOutput := keygenCmd.String("o", "", "Private key file to create; the public key goes to <file>.pub (default: signing.key in the Gitspace home)")
+	keygenComment := keygenCmd.String("comment", "", "Comment identifying the key's owner (default: git config user.email)")
+	keygenConfig := addConfigFlags(keygenCmd)
+
+	signCmd := flag.NewFlagSet("sign", flag.ExitOnError)
+	signKey := signCmd.String("key", "", "Private key to sign with (default: signing.key in the Gitspace home)")
+	signConfig := addConfigFlags(signCmd)
+
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyConfig := addConfigFlags(verifyCmd)
+
+	trustCmd := flag.NewFlagSet("trust", flag.ExitOnError)
+	trustList := trustCmd.Bool("list", false, "List the trusted keys")
+	trustRemove := trustCmd.String("remove", "", "Stop trusting the key with this ID")
+	trustConfig := addConfigFlags(trustCmd)
 
 	updateDepsCmd := flag.NewFlagSet("update-deps", flag.ExitOnError)
+	updateDepsDryRun := updateDepsCmd.Bool("dry-run", false, "List dependency changes without writing go.mod")
+	updateDepsDiff := updateDepsCmd.Bool("diff", false, "Print a unified diff of go.mod without writing it")
+	updateDepsJSON := updateDepsCmd.Bool("json", false, "Print dependency changes as JSON without writing go.mod")
+	updateDepsConfig := addConfigFlags(updateDepsCmd)
 
 	updateVersionCmd := flag.NewFlagSet("update-version", flag.ExitOnError)
+	updateVersionConfig := addConfigFlags(updateVersionCmd)
+
+	checkDepsCmd := flag.NewFlagSet("check-deps", flag.ExitOnError)
+	checkDepsJSON := checkDepsCmd.Bool("json", false, "Print conflicts as JSON")
+	checkDepsConfig := addConfigFlags(checkDepsCmd)
+
+	validateCmd := flag.NewFlagSet("validate", flag.ExitOnError)
+	validateStrict := validateCmd.Bool("strict", false, "Treat unknown manifest keys as errors")
+
+	newCmd := flag.NewFlagSet("new", flag.ExitOnError)
+	newTemplate := newCmd.String("template", gsplug.DefaultTemplate, "Project template: "+strings.Join(gsplug.TemplateNames(), ", "))
+	newModule := newCmd.String("module", "", "Go module path (default: the plugin name)")
+	newAuthor := newCmd.String("author", "", "Plugin author (default: git config user.name)")
+	newDescription := newCmd.String("description", "", "Plugin description")
+	newDir := newCmd.String("dir", "", "Directory to create (default: ./<name>)")
+	newList := newCmd.Bool("list", false, "List the available templates")
+	newConfig := addConfigFlags(newCmd)
 
 	versionCmd := flag.NewFlagSet("version", flag.ExitOnError)
 
 	if len(os.Args) < 2 {
-		fmt.Println("Expected 'build', 'update-deps', 'update-version', or 'version' subcommands")
+		fmt.Println("Expected 'build', 'package', 'inspect', 'install', 'uninstall', 'list', 'sign', 'verify', 'keygen', 'trust', 'update-deps', 'check-deps', 'update-version', 'validate', 'new', 'cache', 'config', or 'version' subcommands")
 		os.Exit(1)
 	}
 
 	switch os.Args[1] {
 	case "build":
 		buildCmd.Parse(os.Args[2:])
+		cfg := buildConfig.load()
+		opts := buildRunner.options(cfg)
+		opts.Jobs = *buildJobs
+		opts.FailFast = *buildFailFast
+		opts.Force = *buildForce
+		opts.OnResult = printBuildResult
 		if *buildAll {
-			if err := gsplug.BuildAllPlugins(); err != nil {
+			report, err := cfg.BuildAll(context.Background(), opts)
+			if err != nil {
 				fmt.Printf("Error building all plugins: %v\n", err)
 				os.Exit(1)
 			}
+			fmt.Printf("Built %d of %d plugins in %s\n", len(report.Results)-len(report.Failed()), len(report.Results), report.Duration.Round(time.Millisecond))
+			if err := report.Err(); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
 		} else {
 			if buildCmd.NArg() < 1 {
 				fmt.Println("Please specify a plugin directory")
 				os.Exit(1)
 			}
-			pluginDir := buildCmd.Arg(0)
-			if err := gsplug.BuildPlugin(pluginDir); err != nil {
-				fmt.Printf("Error building plugin: %v\n", err)
+			result := cfg.Build(context.Background(), buildCmd.Arg(0), opts)
+			if result.Err != nil {
+				fmt.Printf("Error building plugin: %v\n", result.Err)
+				os.Exit(1)
+			}
+			if result.Cached {
+				fmt.Println("Plugin is up to date")
+			}
+		}
+
+	case "package":
+		packageCmd.Parse(os.Args[2:])
+		cfg := packageConfig.load()
+		if packageCmd.NArg() < 1 {
+			fmt.Println("Please specify a plugin directory")
+			os.Exit(1)
+		}
+		opts := gsplug.PackageOptions{OutputDir: *packageOutput, Build: packageRunner.options(cfg)}
+		opts.Build.Force = *packageForce
+		archive, err := cfg.Package(context.Background(), packageCmd.Arg(0), opts)
+		if err != nil {
+			fmt.Printf("Error packaging plugin: %v\n", err)
+			os.Exit(1)
+		}
+		// Read the archive back so a broken package is never reported as a success
+		pkg, err := gsplug.ReadPackage(archive)
+		if err != nil {
+			fmt.Printf("Error verifying package: %v\n", err)
+			os.Exit(1)
+		}
+		for _, file := range pkg.Files {
+			fmt.Printf("  %s\n", file.Name)
+		}
+		fmt.Printf("Packaged %s %s for %s: %s\n", pkg.Info.Name, pkg.Info.Version, pkg.Info.Platform, archive)
+
+	case "inspect":
+		inspectCmd.Parse(os.Args[2:])
+		if inspectCmd.NArg() < 1 {
+			fmt.Println("Please specify a plugin package")
+			os.Exit(1)
+		}
+		pkg, err := gsplug.ReadPackage(inspectCmd.Arg(0))
+		if err != nil {
+			fmt.Printf("Error reading package: %v\n", err)
+			os.Exit(1)
+		}
+		if *inspectJSON {
+			data, err := json.MarshalIndent(pkg, "", "  ")
+			if err != nil {
+				fmt.Printf("Error encoding package: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			break
+		}
+		fmt.Printf("Name:        %s\n", pkg.Info.Name)
+		fmt.Printf("Version:     %s\n", pkg.Info.Version)
+		fmt.Printf("Description: %s\n", pkg.Manifest.Metadata.Description)
+		fmt.Printf("Platform:    %s\n", pkg.Info.Platform)
+		fmt.Printf("Toolchain:   %s\n", pkg.Info.Toolchain)
+		for _, artifact := range pkg.Info.Artifacts {
+			fmt.Printf("Artifact:    %s (%s)\n", artifact.Path, artifact.Mode)
+		}
+		fmt.Println("Files:")
+		for _, file := range pkg.Files {
+			fmt.Printf("  %s  %s %8d  %s\n", file.SHA256, file.Mode, file.Size, file.Name)
+		}
+
+	case "install":
+		installCmd.Parse(os.Args[2:])
+		cfg := installConfig.load()
+		if installCmd.NArg() < 1 {
+			fmt.Println("Please specify a plugin package, directory or Git repository")
+			os.Exit(1)
+		}
+		installed, err := cfg.Install(context.Background(), installCmd.Arg(0), gsplug.InstallOptions{
+			Ref:   *installRef,
+			Force: *installForce,
+			Build: installRunner.options(cfg),
+		})
+		if err != nil {
+			fmt.Printf("Error installing plugin: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Installed %s %s from %s into %s\n", installed.Name, installed.Version, installed.Source, filepath.Join(cfg.PluginsPath(), installed.Dir))
+
+	case "uninstall":
+		uninstallCmd.Parse(os.Args[2:])
+		cfg := uninstallConfig.load()
+		if uninstallCmd.NArg() < 1 {
+			fmt.Println("Please specify a plugin name")
+			os.Exit(1)
+		}
+		if err := cfg.Uninstall(uninstallCmd.Arg(0)); err != nil {
+			fmt.Printf("Error uninstalling plugin: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Uninstalled %s\n", uninstallCmd.Arg(0))
+
+	case "list":
+		listCmd.Parse(os.Args[2:])
+		cfg := listConfig.load()
+		plugins, err := cfg.ListPlugins()
+		if err != nil {
+			fmt.Printf("Error listing plugins: %v\n", err)
+			os.Exit(1)
+		}
+		if *listJSON {
+			data, err := json.MarshalIndent(plugins, "", "  ")
+			if err != nil {
+				fmt.Printf("Error encoding plugins: %v\n", err)
 				os.Exit(1)
 			}
+			fmt.Println(string(data))
+			break
+		}
+		if len(plugins) == 0 {
+			fmt.Printf("No plugins installed in %s\n", cfg.PluginsPath())
+			break
+		}
+		printPlugins(plugins)
+
+	case "keygen":
+		keygenCmd.Parse(os.Args[2:])
+		cfg := keygenConfig.load()
+		path := *keygenOutput
+		if path == "" {
+			path = cfg.SigningKeyPath()
+		}
+		comment := *keygenComment
+		if comment == "" {
+			if out, err := exec.Command("git", "config", "user.email").Output(); err == nil {
+				comment = strings.TrimSpace(string(out))
+			}
+		}
+		key, err := gsplug.GenerateSigningKey(path, comment)
+		if err != nil {
+			fmt.Printf("Error generating key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created private key %s and public key %s.pub\n", path, path)
+		fmt.Printf("Key ID %s. Share the public key; others trust it with:\n  gsplug trust %s.pub\n", key.ID(), path)
+
+	case "sign":
+		signCmd.Parse(os.Args[2:])
+		cfg := signConfig.load()
+		if signCmd.NArg() < 1 {
+			fmt.Println("Please specify plugin directories or artifacts to sign")
+			os.Exit(1)
+		}
+		keyPath := *signKey
+		if keyPath == "" {
+			keyPath = cfg.SigningKeyPath()
+		}
+		key, err := gsplug.ReadSigningKey(keyPath)
+		if err != nil {
+			fmt.Printf("Error reading signing key: %v\n", err)
+			os.Exit(1)
+		}
+		for _, target := range signCmd.Args() {
+			var signatures []string
+			if info, statErr := os.Stat(target); statErr == nil && info.IsDir() {
+				signatures, err = gsplug.SignPlugin(target, key)
+			} else {
+				var signature string
+				if signature, err = gsplug.SignArtifact(target, key); err == nil {
+					signatures = append(signatures, signature)
+				}
+			}
+			for _, signature := range signatures {
+				fmt.Printf("Signed %s\n", strings.TrimSuffix(signature, gsplug.SignatureExt))
+			}
+			if err != nil {
+				fmt.Printf("Error signing %s: %v\n", target, err)
+				os.Exit(1)
+			}
+		}
+
+	case "verify":
+		verifyCmd.Parse(os.Args[2:])
+		cfg := verifyConfig.load()
+		if verifyCmd.NArg() < 1 {
+			fmt.Println("Please specify plugin directories, packages or artifacts to verify")
+			os.Exit(1)
+		}
+		failed := false
+		for _, target := range verifyCmd.Args() {
+			if err := verifyTarget(cfg, target); err != nil {
+				fmt.Printf("FAIL %s: %v\n", target, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+
+	case "trust":
+		trustCmd.Parse(os.Args[2:])
+		cfg := trustConfig.load()
+		switch {
+		case *trustList:
+			keys, err := cfg.TrustedKeys()
+			if err != nil {
+				fmt.Printf("Error reading trusted keys: %v\n", err)
+				os.Exit(1)
+			}
+			if len(keys) == 0 {
+				fmt.Printf("No keys are trusted; add them to %s with 'gsplug trust <key.pub>'\n", cfg.TrustedKeysPath())
+			}
+			for _, key := range keys {
+				fmt.Printf("%s  %s\n", key.ID(), key.Comment)
+			}
+		case *trustRemove != "":
+			if err := cfg.UntrustKey(*trustRemove); err != nil {
+				fmt.Printf("Error removing key: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Removed key %s\n", *trustRemove)
+		default:
+			if trustCmd.NArg() < 1 {
+				fmt.Println("Usage: gsplug trust <key.pub | public key> | -list | -remove <id>")
+				os.Exit(1)
+			}
+			text := strings.Join(trustCmd.Args(), " ")
+			if data, err := os.ReadFile(trustCmd.Arg(0)); err == nil {
+				text = string(data)
+			}
+			key, err := gsplug.ParsePublicKey(text)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			if err := cfg.TrustKey(key); err != nil {
+				fmt.Printf("Error trusting key: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Trusted key %s %s\n", key.ID(), key.Comment)
 		}
 
 	case "update-deps":
 		updateDepsCmd.Parse(os.Args[2:])
+		cfg := updateDepsConfig.load()
 		if updateDepsCmd.NArg() < 1 {
 			fmt.Println("Please specify a plugin directory")
 			os.Exit(1)
 		}
 		pluginDir := updateDepsCmd.Arg(0)
-		if err := gsplug.UpdatePluginDependencies(pluginDir); err != nil {
+
+		// Canonical versions are applied when canonical-deps.json is present
+		var canonical *gsplug.CanonicalDeps
+		canonicalDeps, err := cfg.GetCanonicalDeps()
+		if err == nil {
+			canonical = &canonicalDeps
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("Error reading canonical dependencies: %v\n", err)
+			os.Exit(1)
+		}
+
+		plan, err := cfg.PlanDependencyUpdate(pluginDir, canonical)
+		if err != nil {
+			fmt.Printf("Error updating plugin dependencies: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *updateDepsJSON {
+			data, err := json.MarshalIndent(plan.Changes, "", "  ")
+			if err != nil {
+				fmt.Printf("Error encoding dependency changes: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+		}
+		if *updateDepsDiff {
+			fmt.Print(plan.Diff())
+		}
+		if *updateDepsDryRun {
+			for _, change := range plan.Changes {
+				if change.OldVersion != change.NewVersion {
+					fmt.Printf("%s %s -> %s (%s)\n", change.Module, change.OldVersion, change.NewVersion, change.Reason)
+				}
+			}
+			if !plan.Changed() {
+				fmt.Println("go.mod is already up to date")
+			}
+		}
+		if *updateDepsDryRun || *updateDepsDiff || *updateDepsJSON {
+			break
+		}
+
+		if err := plan.Apply(); err != nil {
 			fmt.Printf("Error updating plugin dependencies: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("Plugin dependencies updated successfully")
 
+	case "check-deps":
+		checkDepsCmd.Parse(os.Args[2:])
+		cfg := checkDepsConfig.load()
+		if checkDepsCmd.NArg() < 1 {
+			fmt.Println("Please specify a plugin directory")
+			os.Exit(1)
+		}
+		conflicts, err := cfg.AnalyzeConflicts(checkDepsCmd.Arg(0))
+		if err != nil {
+			fmt.Printf("Error analyzing dependencies: %v\n", err)
+			os.Exit(1)
+		}
+		if *checkDepsJSON {
+			data, err := json.MarshalIndent(conflicts, "", "  ")
+			if err != nil {
+				fmt.Printf("Error encoding conflicts: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+		} else if len(conflicts) == 0 {
+			fmt.Println("No module version conflicts with Gitspace")
+		} else {
+			for _, c := range conflicts {
+				fmt.Printf("%s: plugin %s, gitspace %s\n", c.Module, c.PluginVersion, c.HostVersion)
+				fmt.Printf("    plugin:   %s\n", strings.Join(c.PluginPath, " -> "))
+				fmt.Printf("    gitspace: %s\n", strings.Join(c.HostPath, " -> "))
+			}
+		}
+		if len(conflicts) > 0 {
+			os.Exit(1)
+		}
+
 	case "update-version":
 		updateVersionCmd.Parse(os.Args[2:])
-		if err := gsplug.UpdateVersionFile(); err != nil {
+		cfg := updateVersionConfig.load()
+		if err := cfg.UpdateVersionFile(); err != nil {
 			fmt.Printf("Error updating version file: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("Version file updated successfully")
 
+	case "validate":
+		validateCmd.Parse(os.Args[2:])
+		if validateCmd.NArg() < 1 {
+			fmt.Println("Please specify a plugin directory")
+			os.Exit(1)
+		}
+		manifestPath := validateCmd.Arg(0)
+		if info, err := os.Stat(manifestPath); err == nil && info.IsDir() {
+			manifestPath = filepath.Join(manifestPath, gsplug.ManifestFileName)
+		}
+		diags, err := gsplug.ValidateManifestFile(manifestPath, gsplug.ValidateOptions{Strict: *validateStrict})
+		if err != nil {
+			fmt.Printf("Error reading manifest: %v\n", err)
+			os.Exit(1)
+		}
+		for _, d := range diags {
+			fmt.Printf("%s:%s\n", manifestPath, d)
+		}
+		if gsplug.HasErrors(diags) {
+			os.Exit(1)
+		}
+		fmt.Println("Manifest is valid")
+
+	case "new":
+		newCmd.Parse(os.Args[2:])
+		if *newList {
+			for _, name := range gsplug.TemplateNames() {
+				fmt.Printf("%-10s %s\n", name, gsplug.TemplateDescription(name))
+			}
+			break
+		}
+		if newCmd.NArg() < 1 {
+			fmt.Println("Usage: gsplug new [flags] <name>")
+			os.Exit(1)
+		}
+		cfg := newConfig.load()
+		author := *newAuthor
+		if author == "" {
+			if out, err := exec.Command("git", "config", "user.name").Output(); err == nil {
+				author = strings.TrimSpace(string(out))
+			}
+		}
+		files, err := cfg.Scaffold(gsplug.ScaffoldOptions{
+			Name:        newCmd.Arg(0),
+			Dir:         *newDir,
+			Template:    *newTemplate,
+			ModulePath:  *newModule,
+			Author:      author,
+			Description: *newDescription,
+		})
+		if err != nil {
+			fmt.Printf("Error creating plugin: %v\n", err)
+			os.Exit(1)
+		}
+		for _, file := range files {
+			fmt.Printf("Created %s\n", file)
+		}
+		dir := filepath.Dir(files[0])
+		fmt.Printf("\nNext steps:\n  cd %s\n  go mod tidy\n  go test ./...\n  gsplug build .\n", dir)
+
+	case "cache":
+		runCache(os.Args[2:])
+
+	case "config":
+		runConfig(os.Args[2:])
+
 	case "version":
 		versionCmd.Parse(os.Args[2:])
-		fmt.Printf("gsplug version %s\n", version)
+		fmt.Printf("gsplug version %s (plugin API %s)\n", gsplug.Version, gsplug.PluginAPIVersion)
 
 	default:
-		fmt.Println("Expected 'build', 'update-deps', 'update-version', or 'version' subcommands")
+		fmt.Println("Expected 'build', 'package', 'inspect', 'install', 'uninstall', 'list', 'sign', 'verify', 'keygen', 'trust', 'update-deps', 'check-deps', 'update-version', 'validate', 'new', 'cache', 'config', or 'version' subcommands")
+		os.Exit(1)
+	}
+}
+
+// printBuildResult reports the outcome of one plugin built by `build -all`, including the
+// go command's output for failures
+func printBuildResult(result gsplug.BuildResult) {
+	if errors.Is(result.Err, gsplug.ErrBuildCanceled) {
+		fmt.Printf("SKIP %s\n", result.Plugin)
+		return
+	}
+
+	duration := result.Duration.Round(time.Millisecond)
+	if result.Err != nil {
+		fmt.Printf("FAIL %s (%s): %v\n", result.Plugin, duration, result.Err)
+		if output := strings.TrimRight(string(result.Output), "\n"); output != "" {
+			fmt.Printf("    %s\n", strings.ReplaceAll(output, "\n", "\n    "))
+		}
+		return
+	}
+
+	paths := make([]string, len(result.Artifacts))
+	for i, artifact := range result.Artifacts {
+		paths[i] = artifact.Path
+	}
+	if result.Cached {
+		fmt.Printf("ok   %s (up to date): %s\n", result.Plugin, strings.Join(paths, ", "))
+		return
+	}
+	fmt.Printf("ok   %s (%s): %s\n", result.Plugin, duration, strings.Join(paths, ", "))
+}
+
+// verifyTarget verifies the signatures of a plugin directory's artifacts, of the artifacts
+// in a package, or of a single artifact, printing the key that signed each
+func verifyTarget(cfg *gsplug.Config, target string) error {
+	if strings.HasSuffix(target, gsplug.PackageExt) {
+		signers, err := cfg.VerifyPackage(target, true)
+		if err != nil {
+			return err
+		}
+		artifacts := make([]string, 0, len(signers))
+		for artifact := range signers {
+			artifacts = append(artifacts, artifact)
+		}
+		sort.Strings(artifacts)
+		for _, artifact := range artifacts {
+			key := signers[artifact]
+			fmt.Printf("ok   %s: %s signed by %s %s\n", target, artifact, key.ID(), key.Comment)
+		}
+		return nil
+	}
+
+	artifacts := []string{target}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		manifest, err := gsplug.ReadManifest(filepath.Join(target, gsplug.ManifestFileName))
+		if err != nil {
+			return err
+		}
+		built, err := manifest.Artifacts(target)
+		if err != nil {
+			return err
+		}
+		artifacts = artifacts[:0]
+		for _, artifact := range built {
+			artifacts = append(artifacts, artifact.Path)
+		}
+	}
+
+	for _, artifact := range artifacts {
+		key, err := cfg.VerifyArtifact(artifact)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("ok   %s signed by %s %s\n", artifact, key.ID(), key.Comment)
+	}
+	return nil
+}
+
+// printPlugins prints a table of installed plugins, followed by the reasons any of them
+// cannot be used
+func printPlugins(plugins []gsplug.PluginStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tSTATUS\tBUILT\tARTIFACTS")
+	for _, p := range plugins {
+		status := "ok"
+		if !p.Compatible {
+			status = "incompatible"
+		}
+
+		built := "never"
+		if !p.BuiltAt.IsZero() {
+			built = p.BuiltAt.Local().Format("2006-01-02 15:04")
+		}
+
+		artifacts := make([]string, len(p.Artifacts))
+		for i, artifact := range p.Artifacts {
+			artifacts[i] = filepath.Base(artifact.Path)
+			if !artifact.Present {
+				artifacts[i] += " (missing)"
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.Version, status, built, strings.Join(artifacts, ", "))
+	}
+	w.Flush()
+
+	for _, p := range plugins {
+		if p.Problem != "" {
+			fmt.Printf("%s: %s\n", p.Name, p.Problem)
+		}
+	}
+}
+
+// runnerFlags are the flags shared by subcommands that build plugins
+type runnerFlags struct {
+	toolchain    *string
+	hermetic     *bool
+	engine       *string
+	goroot       *string
+	reproducible *bool
+}
+
+// addRunnerFlags registers -toolchain, -hermetic, -engine, -goroot and -reproducible on a
+// subcommand
+func addRunnerFlags(fs *flag.FlagSet) *runnerFlags {
+	return &runnerFlags{
+		toolchain:    fs.String("toolchain", string(gsplug.ToolchainFail), "When the local Go differs from the host's for a Go plugin: fail, auto (switch via GOTOOLCHAIN) or ignore"),
+		hermetic:     fs.Bool("hermetic", false, "Build with the Gitspace host's Go toolchain in a golang container"),
+		engine:       fs.String("engine", "", "Container engine for -hermetic: docker or podman (default: whichever is installed)"),
+		goroot:       fs.String("goroot", "", "Build with the Go installation in this GOROOT, which must match the host's toolchain (implies -hermetic)"),
+		reproducible: fs.Bool("reproducible", false, "Build reproducibly and date the build by $SOURCE_DATE_EPOCH or the last commit"),
+	}
+}
+
+// options returns build options selecting the toolchain policy and runner from the flags
+func (f *runnerFlags) options(cfg *gsplug.Config) gsplug.BuildOptions {
+	toolchain, err := gsplug.ParseToolchainPolicy(*f.toolchain)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
+	opts := gsplug.BuildOptions{Toolchain: toolchain, Reproducible: *f.reproducible}
+
+	if *f.hermetic || *f.goroot != "" {
+		runner, err := cfg.HermeticRunner(*f.engine, *f.goroot)
+		if err != nil {
+			fmt.Printf("Error setting up hermetic build: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Building with %s\n", runner.Name())
+		opts.Runner = runner
+	}
+
+	return opts
+}
+
+// configFlags are the flags shared by subcommands that use the Gitspace home directory
+type configFlags struct {
+	config     *string
+	home       *string
+	pluginsDir *string
+	offline    *bool
+}
+
+// addConfigFlags registers -config, -home, -plugins-dir and -offline on a subcommand
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	return &configFlags{
+		config:     fs.String("config", "", "Path to the gsplug config file (also set by "+gsplug.ConfigFileEnv+")"),
+		home:       fs.String("home", "", "Gitspace home directory (also set by "+gsplug.HomeEnv+")"),
+		pluginsDir: fs.String("plugins-dir", "", "Gitspace plugins directory (also set by "+gsplug.PluginsDirEnv+")"),
+		offline:    fs.Bool("offline", false, "Do not access the network; use cached downloads (also enabled by "+gsplug.OfflineEnv+"=1)"),
+	}
+}
+
+// load resolves the configuration, letting flags override the config file and environment
+func (f *configFlags) load() *gsplug.Config {
+	cfg, err := gsplug.LoadConfig(*f.config)
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *f.home != "" {
+		cfg.Home = *f.home
+	}
+	if *f.pluginsDir != "" {
+		cfg.PluginsDir = *f.pluginsDir
+	}
+	if *f.offline {
+		cfg.Offline = true
+	}
+
+	return cfg
+}
+
+// runCache implements the cache subcommands
+func runCache(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Expected 'list' or 'seed' cache subcommands")
+		os.Exit(1)
+	}
+
+	cacheCmd := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	cacheConfig := addConfigFlags(cacheCmd)
+	cacheCmd.Parse(args[1:])
+	args = append(args[:1], cacheCmd.Args()...)
+	cfg := cacheConfig.load()
+
+	switch args[0] {
+	case "list":
+		entries, err := cfg.CacheEntries()
+		if err != nil {
+			fmt.Printf("Error reading cache: %v\n", err)
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Println("Cache is empty")
+		}
+		for _, entry := range entries {
+			source := entry.URL
+			if entry.Source != "" {
+				source = "seeded from " + entry.Source
+			}
+			fmt.Printf("%s\t%s\t%s\n", entry.Name, entry.FetchedAt.Format(time.RFC3339), source)
+		}
+
+	case "seed":
+		if len(args) < 3 {
+			fmt.Printf("Usage: gsplug cache seed <%s> <file>\n", strings.Join(gsplug.CacheNames(), "|"))
+			os.Exit(1)
+		}
+		if err := cfg.SeedCache(args[1], args[2]); err != nil {
+			fmt.Printf("Error seeding cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Cached %s from %s\n", args[1], args[2])
+
+	default:
+		fmt.Println("Expected 'list' or 'seed' cache subcommands")
+		os.Exit(1)
+	}
+}
+
+// runConfig implements the config subcommands, which manage the settings a plugin declares
+// in its manifest
+func runConfig(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Expected 'list', 'get' or 'set' config subcommands")
+		os.Exit(1)
+	}
+
+	configCmd := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	configShowSecrets := configCmd.Bool("show-secrets", false, "Print the values of secret settings (with list)")
+	configConfig := addConfigFlags(configCmd)
+	configCmd.Parse(args[1:])
+	args = append(args[:1], configCmd.Args()...)
+	cfg := configConfig.load()
+
+	usage := map[string]string{
+		"list": "Usage: gsplug config list [-show-secrets] <plugin>",
+		"get":  "Usage: gsplug config get <plugin> <setting>",
+		"set":  "Usage: gsplug config set <plugin> <setting> <value>",
+	}
+	want := map[string]int{"list": 2, "get": 3, "set": 4}
+	if _, ok := usage[args[0]]; !ok {
+		fmt.Println("Expected 'list', 'get' or 'set' config subcommands")
+		os.Exit(1)
+	}
+	if len(args) != want[args[0]] {
+		fmt.Println(usage[args[0]])
+		os.Exit(1)
+	}
+
+	manifest, err := configPluginManifest(cfg, args[1])
+	if err != nil {
+		fmt.Printf("Error finding plugin: %v\n", err)
+		os.Exit(1)
+	}
+	name := manifest.Metadata.Name
+
+	if args[0] == "set" {
+		if err := cfg.SetPluginConfig(manifest, args[2], args[3]); err != nil {
+			fmt.Printf("Error setting %s: %v\n", args[2], err)
+			os.Exit(1)
+		}
+		fmt.Printf("Set %s for plugin %s in %s\n", args[2], name, cfg.PluginConfigPath(name))
+		if env := gsplug.PluginConfigEnv(name, args[2]); os.Getenv(env) != "" {
+			fmt.Printf("Note: %s is set and overrides this value\n", env)
+		}
+		return
+	}
+
+	config, err := cfg.LoadPluginConfig(manifest)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		settings := config.Settings()
+		if len(settings) == 0 {
+			fmt.Printf("Plugin %s has no settings\n", name)
+			break
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SETTING\tTYPE\tVALUE\tSOURCE\tDESCRIPTION")
+		for _, setting := range settings {
+			value := gsplug.FormatConfigValue(setting.Value)
+			switch {
+			case setting.Value == nil:
+				value = "-"
+			case setting.Field.Secret && !*configShowSecrets:
+				value = "********"
+			}
+			source := setting.Source
+			switch {
+			case source == gsplug.ConfigSourceEnv:
+				source = setting.Env
+			case source == "" && setting.Field.Required:
+				source = "missing"
+			case source == "":
+				source = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", setting.Field.Name, setting.Field.EffectiveType(), value, source, setting.Field.Description)
+		}
+		w.Flush()
+
+	case "get":
+		value, ok := config.Get(args[2])
+		if !ok {
+			for _, setting := range config.Settings() {
+				if setting.Field.Name == args[2] {
+					fmt.Printf("%s is not set\n", args[2])
+					os.Exit(1)
+				}
+			}
+			fmt.Printf("Plugin %s has no setting %q\n", name, args[2])
+			os.Exit(1)
+		}
+		fmt.Println(gsplug.FormatConfigValue(value))
+	}
+}
+
+// configPluginManifest returns the manifest of the installed plugin with the given name, or
+// of the plugin in the given directory
+func configPluginManifest(cfg *gsplug.Config, plugin string) (*gsplug.PluginManifest, error) {
+	manifestPath := filepath.Join(plugin, gsplug.ManifestFileName)
+	if _, err := os.Stat(manifestPath); err == nil {
+		return gsplug.ReadManifest(manifestPath)
+	}
+	_, manifest, err := cfg.FindPlugin(plugin)
+	return manifest, err
 }
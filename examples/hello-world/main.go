@@ -4,6 +4,9 @@
 package main
 
 import (
+	"context"
+	_ "embed"
+	"log/slog"
 	"os"
 	"path/filepath"
 
@@ -12,34 +15,39 @@ import (
 	"github.com/ssotops/gitspace-plugin/gsplug"
 )
 
+// manifestData is gitspace-plugin.toml, embedded for hosts that do not pass a PluginContext
+//
+//go:embed gitspace-plugin.toml
+var manifestData []byte
+
 var Plugin HelloWorldPlugin
 
+// GitspacePluginAPIVersion tells the host which plugin API this plugin was built against
+var GitspacePluginAPIVersion = gsplug.PluginAPIVersion
+
+// Fail the build if HelloWorldPlugin drifts from the interface Gitspace loads
+var _ gsplug.Plugin = (*HelloWorldPlugin)(nil)
+
 type HelloWorldPlugin struct {
 	manifest *gsplug.PluginManifest
-	logger   *log.Logger
+	logger   *slog.Logger
 }
 
-func (p *HelloWorldPlugin) Init() error {
-	var err error
-
-	// Get the directory of the current executable
-	exePath, err := os.Executable()
-	if err != nil {
-		return err
-	}
-	exeDir := filepath.Dir(exePath)
-
-	// Construct the path to gitspace-plugin.toml relative to the executable
-	manifestPath := filepath.Join(exeDir, "gitspace-plugin.toml")
+// InitContext is called by hosts that hand the plugin its manifest and logger
+func (p *HelloWorldPlugin) InitContext(pc *gsplug.PluginContext) error {
+	p.manifest = pc.Manifest
+	p.logger = pc.Logger
+	return nil
+}
 
-	p.manifest, err = gsplug.ReadManifest(manifestPath)
+// Init is called by older hosts, which leave the plugin to set itself up
+func (p *HelloWorldPlugin) Init() error {
+	manifest, err := gsplug.ParseManifest(manifestData)
 	if err != nil {
 		return err
 	}
-
-	p.logger = log.New(os.Stderr)
-	p.logger.SetReportCaller(true)
-
+	p.manifest = manifest
+	p.logger = slog.New(log.New(os.Stderr))
 	return nil
 }
 
@@ -101,11 +109,34 @@ func (p HelloWorldPlugin) GetMenuOption() *gsplug.Option {
 }
 
 func main() {
-	if err := Plugin.Init(); err != nil {
+	// When started by Gitspace as an out-of-process plugin, serve the plugin protocol instead
+	if gsplug.IsPluginProcess() {
+		if err := gsplug.ServePlugin(&Plugin); err != nil {
+			log.Fatal("Failed to serve plugin", "error", err)
+		}
+		return
+	}
+
+	// Run standalone the way a host would, from the directory holding the executable
+	manifest, err := gsplug.ParseManifest(manifestData)
+	if err != nil {
+		log.Fatal("Failed to parse plugin manifest", "error", err)
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		log.Fatal("Failed to locate plugin", "error", err)
+	}
+	pc, err := gsplug.NewPluginContext(context.Background(), manifest, filepath.Dir(exePath))
+	if err != nil {
+		log.Fatal("Failed to set up plugin", "error", err)
+	}
+	pc.Logger = slog.New(log.New(os.Stderr)).With("plugin", manifest.Metadata.Name)
+
+	if err := gsplug.InitPlugin(&Plugin, pc); err != nil {
 		log.Fatal("Failed to initialize plugin", "error", err)
 	}
 
-	if err := Plugin.Run(); err != nil {
+	if err := gsplug.RunPlugin(&Plugin, pc); err != nil {
 		log.Fatal("Error running plugin", "error", err)
 	}
 }
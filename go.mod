@@ -6,3 +6,5 @@ require (
 	github.com/Masterminds/semver/v3 v3.3.0
 	github.com/pelletier/go-toml/v2 v2.2.3
 )
+
+require golang.org/x/mod v0.21.0
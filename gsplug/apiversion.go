@@ -0,0 +1,207 @@
+package gsplug
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// PluginAPIVersion is the plugin API version implemented by this copy of gsplug.
+// Bump the major version on any breaking change to Plugin, its optional
+// interfaces or the out-of-process protocol, and update apiCompatibility. Bump
+// the minor version when adding interfaces or manifest tables that hosts act on,
+// so that older hosts refuse plugins relying on them.
+const PluginAPIVersion = "1.1.0"
+
+// APIVersionSymbol is the exported string variable through which a plugin built
+// with -buildmode=plugin reports the API version it was compiled against:
+//
+//	var GitspacePluginAPIVersion = gsplug.PluginAPIVersion
+const APIVersionSymbol = "GitspacePluginAPIVersion"
+
+// LegacyPluginAPIVersion is assumed for plugins that do not report an API version. gsplug
+// build refuses to build Go plugins that do not declare APIVersionSymbol.
+const LegacyPluginAPIVersion = "1.0.0"
+
+// apiCompatibility is the compatibility matrix between host and plugin API major
+// versions: for each host major, the plugin majors it is able to load.
+//
+//	host \ plugin | 1
+//	--------------+---
+//	1             | ✓
+//
+// A plugin major other than the host's own must have an entry in apiAdapters.
+// Within a major version, hosts load plugins of the same or an older minor:
+//
+//	1.0  Plugin, Shutdowner, Configurable, the out-of-process protocol
+//	1.1  ContextInitializer, ContextRunner, Stopper, MenuContributor, and the
+//	     [runtime] and [[config]] manifest tables
+var apiCompatibility = map[uint64][]uint64{
+	1: {1},
+}
+
+// apiAdapters wrap entry points written against an older plugin API major
+// version so that they satisfy the current Plugin interface.
+var apiAdapters = map[uint64]func(sym plugin.Symbol) (Plugin, error){}
+
+// APINegotiation is the outcome of matching a plugin's API version against the host's
+type APINegotiation struct {
+	Host   string
+	Plugin string
+	// Version is the API version both sides speak: the lower of Host and Plugin
+	Version string
+	// Adapted is true when the plugin targets another major version and is loaded through an adapter
+	Adapted bool
+}
+
+// APIVersionError is returned when a plugin's API version cannot be served by the host
+type APIVersionError struct {
+	Host   string
+	Plugin string
+	Reason string
+}
+
+func (e *APIVersionError) Error() string {
+	return fmt.Sprintf("plugin API version %s is not supported by host API version %s: %s", e.Plugin, e.Host, e.Reason)
+}
+
+// NegotiateAPIVersion decides whether a host speaking hostVersion can load a plugin
+// built against pluginVersion. An empty pluginVersion is treated as LegacyPluginAPIVersion.
+func NegotiateAPIVersion(hostVersion, pluginVersion string) (*APINegotiation, error) {
+	if pluginVersion == "" {
+		pluginVersion = LegacyPluginAPIVersion
+	}
+
+	host, err := semver.StrictNewVersion(hostVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host API version %q: %w", hostVersion, err)
+	}
+	plug, err := semver.StrictNewVersion(pluginVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin API version %q: %w", pluginVersion, err)
+	}
+
+	negotiation := &APINegotiation{Host: hostVersion, Plugin: pluginVersion}
+
+	if plug.Major() == host.Major() {
+		if plug.Minor() > host.Minor() {
+			return nil, &APIVersionError{Host: hostVersion, Plugin: pluginVersion, Reason: "the plugin uses API features this Gitspace does not provide; upgrade Gitspace"}
+		}
+		negotiation.Version = pluginVersion
+		if host.LessThan(plug) {
+			negotiation.Version = hostVersion
+		}
+		return negotiation, nil
+	}
+
+	for _, supported := range apiCompatibility[host.Major()] {
+		if supported == plug.Major() {
+			negotiation.Version = pluginVersion
+			if host.LessThan(plug) {
+				negotiation.Version = hostVersion
+			}
+			negotiation.Adapted = true
+			return negotiation, nil
+		}
+	}
+
+	reason := "the plugin was built against an API major version this Gitspace no longer supports; rebuild the plugin"
+	if plug.Major() > host.Major() {
+		reason = "the plugin was built against a newer API major version; upgrade Gitspace"
+	}
+	return nil, &APIVersionError{Host: hostVersion, Plugin: pluginVersion, Reason: reason}
+}
+
+// pluginAPIVersion returns the API version a loaded plugin reports through APIVersionSymbol
+func pluginAPIVersion(p *plugin.Plugin) (string, error) {
+	sym, err := p.Lookup(APIVersionSymbol)
+	if err != nil {
+		// Plugins built before API versions were embedded
+		return LegacyPluginAPIVersion, nil
+	}
+
+	switch v := sym.(type) {
+	case *string:
+		return *v, nil
+	default:
+		return "", fmt.Errorf("symbol %s has type %T, want string", APIVersionSymbol, sym)
+	}
+}
+
+// declaresAPIVersion reports whether the package in dir, built with the given tags,
+// declares APIVersionSymbol at package level
+func declaresAPIVersion(dir string, tags []string) (bool, error) {
+	ctx := build.Default
+	ctx.BuildTags = tags
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	fset := token.NewFileSet()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if match, err := ctx.MatchFile(dir, name); err != nil || !match {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return false, err
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, ident := range spec.(*ast.ValueSpec).Names {
+					if ident.Name == APIVersionSymbol {
+						return true, nil
+					}
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// missingAPIVersionError explains how to declare APIVersionSymbol
+func missingAPIVersionError() error {
+	return fmt.Errorf("the main package does not declare %s, so hosts cannot negotiate its plugin API version; add `var %s = gsplug.PluginAPIVersion`",
+		APIVersionSymbol, APIVersionSymbol)
+}
+
+// adaptPlugin loads an entry point written against another API major version through its adapter
+func adaptPlugin(p *plugin.Plugin, name string, negotiation *APINegotiation) (Plugin, error) {
+	version, err := semver.StrictNewVersion(negotiation.Plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	adapter, ok := apiAdapters[version.Major()]
+	if !ok {
+		return nil, &APIVersionError{
+			Host:   negotiation.Host,
+			Plugin: negotiation.Plugin,
+			Reason: fmt.Sprintf("no adapter for plugin API major version %d", version.Major()),
+		}
+	}
+
+	sym, err := p.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("plugin does not export entry point %q: %w", name, err)
+	}
+
+	return adapter(sym)
+}
@@ -1,111 +1,430 @@
 package gsplug
 
 import (
+	"context"
 	"fmt"
-  "io/ioutil"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
-  "strings"
+	"sort"
+	"strings"
+	"time"
 )
 
-// BuildPlugin builds the plugin in the specified directory
+// BuildPlugin builds the plugin in the specified directory using the default configuration
 func BuildPlugin(pluginDir string) error {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.BuildPlugin(pluginDir)
+}
+
+// BuildPlugin builds the plugin in the specified directory, unless it is up to date
+func (c *Config) BuildPlugin(pluginDir string) error {
+	return c.Build(context.Background(), pluginDir, BuildOptions{}).Err
+}
+
+// Build builds the plugin in pluginDir, streaming the go command's output to os.Stdout and
+// os.Stderr. Of opts, only Force, Runner, Toolchain and Reproducible apply to a single build.
+func (c *Config) Build(ctx context.Context, pluginDir string, opts BuildOptions) BuildResult {
+	result := BuildResult{Plugin: filepath.Base(pluginDir), Dir: pluginDir}
+	start := time.Now()
+	result.Artifacts, result.Cached, result.Err = c.buildPlugin(ctx, pluginDir, opts, os.Stdout, os.Stderr)
+	result.Duration = time.Since(start)
+	return result
+}
+
+// buildPlugin builds the plugin in pluginDir, writing the go command's output to stdout
+// and stderr, and returns the artifacts. Unless opts.Force is set, nothing is built if the
+// artifacts were built from the same inputs, and cached is true.
+func (c *Config) buildPlugin(ctx context.Context, pluginDir string, opts BuildOptions, stdout, stderr io.Writer) (artifacts []Artifact, cached bool, err error) {
 	// Ensure the plugin directory exists
 	if _, err := os.Stat(pluginDir); os.IsNotExist(err) {
-		return fmt.Errorf("plugin directory does not exist: %s", pluginDir)
+		return nil, false, fmt.Errorf("plugin directory does not exist: %s", pluginDir)
+	}
+
+	// Container runners mount directories at the same absolute paths
+	if pluginDir, err = filepath.Abs(pluginDir); err != nil {
+		return nil, false, err
+	}
+	runner := opts.Runner
+	if runner == nil {
+		runner = LocalRunner{}
 	}
 
 	// Read the plugin manifest
-	manifest, err := ReadManifest(filepath.Join(pluginDir, "gitspace-plugin.toml"))
+	manifest, err := ReadManifest(filepath.Join(pluginDir, ManifestFileName))
 	if err != nil {
-		return fmt.Errorf("failed to read plugin manifest: %w", err)
+		return nil, false, fmt.Errorf("failed to read plugin manifest: %w", err)
 	}
 
 	// Check compatibility
-	compatible, err := CheckCompatibility(manifest.Metadata.Version)
+	if err := c.CheckManifestCompatibility(manifest); err != nil {
+		return nil, false, fmt.Errorf("plugin %s is not compatible with the current Gitspace: %w", manifest.Metadata.Name, err)
+	}
+
+	canonicalDeps, err := c.GetCanonicalDeps()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get canonical dependencies: %w", err)
+	}
+
+	artifacts, err = manifest.Artifacts(pluginDir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Hosts would assume LegacyPluginAPIVersion for a Go plugin that does not report its own
+	for _, artifact := range artifacts {
+		if artifact.Mode != BuildModePlugin {
+			continue
+		}
+		declared, err := declaresAPIVersion(pluginDir, manifest.Build.Tags)
+		if err != nil {
+			return nil, false, err
+		}
+		if !declared {
+			return nil, false, fmt.Errorf("cannot build plugin %s as a Go plugin: %w", manifest.Metadata.Name, missingAPIVersionError())
+		}
+		break
+	}
+
+	env, err := manifest.Build.environ(c.Offline)
 	if err != nil {
-		return fmt.Errorf("failed to check compatibility: %w", err)
+		return nil, false, err
+	}
+
+	mounts, err := buildMounts(pluginDir, artifacts)
+	if err != nil {
+		return nil, false, err
+	}
+	goCmd := GoCommand{Dir: pluginDir, Env: env, Mounts: mounts}
+
+	reproducible := opts.Reproducible || manifest.Build.Reproducible
+	builtAt := time.Now().UTC()
+	if reproducible {
+		if builtAt, err = sourceDate(ctx, pluginDir); err != nil {
+			return nil, false, err
+		}
+		// Only go.mod and go.sum decide the dependencies, whatever the user's GOFLAGS
+		goCmd.Env = append(goCmd.Env, "GOFLAGS=-mod=readonly", fmt.Sprintf("%s=%d", SourceDateEpochEnv, builtAt.Unix()))
 	}
-	if !compatible {
-		return fmt.Errorf("plugin version %s is not compatible with the current Gitspace version", manifest.Metadata.Version)
+	buildArgs := make([][]string, len(artifacts))
+	for i, artifact := range artifacts {
+		buildArgs[i] = manifest.Build.goBuildArgs(artifact, reproducible)
+	}
+
+	if err := c.EnsureGitspaceModFile(); err != nil {
+		return nil, false, err
 	}
 
-	// Update dependencies
-	if err := UpdatePluginDependencies(pluginDir); err != nil {
-		return fmt.Errorf("failed to update plugin dependencies: %w", err)
+	// Only Go plugins must match the host's toolchain; binaries talk to it over RPC
+	var hostToolchain string
+	for _, artifact := range artifacts {
+		if artifact.Mode != BuildModePlugin {
+			continue
+		}
+		policy, err := ParseToolchainPolicy(string(opts.Toolchain))
+		if err != nil {
+			return nil, false, err
+		}
+		if goCmd.Env, hostToolchain, err = c.selectToolchain(ctx, runner, goCmd, policy); err != nil {
+			return nil, false, err
+		}
+		break
 	}
 
-	// Get the plugin name from the directory name
-	pluginName := filepath.Base(pluginDir)
+	// An up-to-date plugin already has its dependencies pinned, so the fingerprint taken
+	// before updating them matches the one recorded after the last build
+	if !opts.Force {
+		fingerprint, _, _, err := c.buildFingerprint(ctx, runner, goCmd, artifacts, buildArgs, &canonicalDeps)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to fingerprint plugin: %w", err)
+		}
+		if upToDate(pluginDir, artifacts, fingerprint) {
+			return artifacts, true, nil
+		}
+	}
 
-	canonicalDeps, err := GetCanonicalDeps()
+	// Pin go.mod to Gitspace's and the canonical dependency versions
+	plan, err := c.PlanDependencyUpdate(pluginDir, &canonicalDeps)
 	if err != nil {
-		return fmt.Errorf("failed to get canonical dependencies: %w", err)
+		return nil, false, fmt.Errorf("failed to update plugin dependencies: %w", err)
+	}
+	if err := plan.Apply(); err != nil {
+		return nil, false, fmt.Errorf("failed to update go.mod: %w", err)
 	}
 
-	// Update go.mod with canonical versions
-	if err := updateGoMod(pluginDir, canonicalDeps); err != nil {
-		return fmt.Errorf("failed to update go.mod: %w", err)
+	fingerprint, toolchain, platform, err := c.buildFingerprint(ctx, runner, goCmd, artifacts, buildArgs, &canonicalDeps)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fingerprint plugin: %w", err)
 	}
 
-	// Build the plugin
-	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", filepath.Join(pluginDir, "dist", pluginName+".so"))
-	cmd.Dir = pluginDir
-	cmd.Env = append(os.Environ(), "GOPROXY=direct")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// Never leave a record describing artifacts that were only partially rebuilt
+	if err := os.Remove(buildRecordPath(artifacts)); err != nil && !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	// Signatures and provenance of the previous artifacts would not match the new ones
+	for _, artifact := range artifacts {
+		for _, ext := range []string{SignatureExt, ProvenanceExt} {
+			if err := os.Remove(artifact.Path + ext); err != nil && !os.IsNotExist(err) {
+				return nil, false, err
+			}
+		}
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to build plugin: %w", err)
+	requirements, err := parseDependencies(filepath.Join(pluginDir, "go.mod"))
+	if err != nil {
+		return nil, false, err
 	}
+	source := gitSource(ctx, pluginDir)
+
+	for i, artifact := range artifacts {
+		goCmd.Args = buildArgs[i]
+		cmd, err := runner.Command(ctx, goCmd)
+		if err != nil {
+			return nil, false, err
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+
+		if err := cmd.Run(); err != nil {
+			return nil, false, fmt.Errorf("failed to build %s %s: %w", artifact.Mode, artifact.Path, err)
+		}
 
-	return nil
+		// Hosts read the manifest from next to the artifact
+		if err := copyFile(filepath.Join(pluginDir, ManifestFileName), filepath.Join(filepath.Dir(artifact.Path), ManifestFileName)); err != nil {
+			return nil, false, fmt.Errorf("failed to copy manifest next to %s: %w", artifact.Path, err)
+		}
+
+		sum, err := hashFile(artifact.Path)
+		if err != nil {
+			return nil, false, err
+		}
+		provenance := &Provenance{
+			FormatVersion: provenanceFormatVersion,
+			Artifact:      filepath.Base(artifact.Path),
+			Mode:          artifact.Mode,
+			SHA256:        sum,
+			PluginName:    manifest.Metadata.Name,
+			PluginVersion: manifest.Metadata.Version,
+			Source:        source,
+			InputsSHA256:  fingerprint,
+			Toolchain:     toolchain,
+			Platform:      platform,
+			Reproducible:  reproducible,
+			BuildArgs:     relativeOutputArgs(buildArgs[i], pluginDir),
+			Env:           provenanceEnv(goCmd.Env),
+			CanonicalDeps: canonicalDeps.Versions,
+			Requirements:  requirements,
+			GsplugVersion: Version,
+			Runner:        runner.Name(),
+			BuiltAt:       builtAt,
+		}
+		if reproducible {
+			provenance.SourceDateEpoch = builtAt.Unix()
+		}
+		if err := writeProvenance(artifact.Path, provenance); err != nil {
+			return nil, false, fmt.Errorf("failed to write provenance of %s: %w", artifact.Path, err)
+		}
+	}
+
+	record := &BuildRecord{
+		Fingerprint:   fingerprint,
+		Toolchain:     toolchain,
+		HostToolchain: hostToolchain,
+		Platform:      platform,
+		Runner:        runner.Name(),
+		Reproducible:  reproducible,
+		BuiltAt:       builtAt,
+	}
+	for _, artifact := range artifacts {
+		record.Artifacts = append(record.Artifacts, recordedArtifactPath(pluginDir, artifact.Path))
+	}
+	if err := writeBuildRecord(artifacts, record); err != nil {
+		return nil, false, fmt.Errorf("failed to write build record: %w", err)
+	}
+
+	return artifacts, false, nil
+}
+
+// Artifact is a file produced by building a plugin
+type Artifact struct {
+	// Mode is BuildModePlugin or BuildModeBinary
+	Mode string
+	Path string
 }
 
-// BuildAllPlugins builds all plugins in the Gitspace plugins directory
-func BuildAllPlugins() error {
-	pluginsDir := filepath.Join(os.Getenv("HOME"), ".ssot", "gitspace", "plugins")
+// Artifacts returns the artifacts the manifest's [build] table produces for the plugin in pluginDir
+func (m *PluginManifest) Artifacts(pluginDir string) ([]Artifact, error) {
+	// Default output names come from the plugin directory name, even for a relative path like "."
+	absDir, err := filepath.Abs(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+	pluginName := filepath.Base(absDir)
 
-	entries, err := os.ReadDir(pluginsDir)
+	mode, err := m.Build.EffectiveMode()
 	if err != nil {
-		return fmt.Errorf("failed to read plugins directory: %w", err)
+		return nil, err
+	}
+
+	resolve := func(path, fallback string) string {
+		if path == "" {
+			path = fallback
+		}
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(pluginDir, path)
+	}
+
+	var artifacts []Artifact
+	if mode == BuildModePlugin || mode == BuildModeBoth {
+		artifacts = append(artifacts, Artifact{
+			Mode: BuildModePlugin,
+			Path: resolve(m.Build.Plugin, filepath.Join("dist", pluginName+".so")),
+		})
+	}
+	if mode == BuildModeBinary || mode == BuildModeBoth {
+		artifacts = append(artifacts, Artifact{
+			Mode: BuildModeBinary,
+			Path: resolve(m.Build.Binary, filepath.Join("dist", pluginName)),
+		})
+	}
+
+	return artifacts, nil
+}
+
+// EffectiveMode returns the configured build mode, inferring it from the output paths when unset
+func (b BuildConfig) EffectiveMode() (string, error) {
+	switch b.Mode {
+	case BuildModePlugin, BuildModeBinary, BuildModeBoth:
+		return b.Mode, nil
+	case "":
+		switch {
+		case b.Binary != "" && b.Plugin != "":
+			return BuildModeBoth, nil
+		case b.Binary != "":
+			return BuildModeBinary, nil
+		default:
+			return BuildModePlugin, nil
+		}
+	default:
+		return "", fmt.Errorf("unknown build mode %q, expected %s, %s or %s", b.Mode, BuildModePlugin, BuildModeBinary, BuildModeBoth)
 	}
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			pluginDir := filepath.Join(pluginsDir, entry.Name())
-			if err := BuildPlugin(pluginDir); err != nil {
-				fmt.Printf("Failed to build plugin %s: %v\n", entry.Name(), err)
+// goBuildArgs returns the arguments to `go` that build the given artifact. Reproducible
+// builds strip file system paths and use an empty build ID, which otherwise hashes them.
+func (b BuildConfig) goBuildArgs(artifact Artifact, reproducible bool) []string {
+	args := []string{"build"}
+	if artifact.Mode == BuildModePlugin {
+		args = append(args, "-buildmode=plugin")
+	}
+	if b.Trimpath || reproducible {
+		args = append(args, "-trimpath")
+	}
+	if len(b.Tags) > 0 {
+		tags := append([]string(nil), b.Tags...)
+		sort.Strings(tags)
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+	ldflags := b.LDFlags
+	if reproducible {
+		ldflags = strings.TrimSpace(ldflags + " -buildid=")
+	}
+	if ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	return append(args, "-o", artifact.Path, ".")
+}
+
+// provenanceEnv returns the build variables that can affect the artifact. GOPROXY only
+// decides where modules are fetched from; go.sum pins their content.
+func provenanceEnv(env []string) []string {
+	var kept []string
+	for _, variable := range env {
+		if !strings.HasPrefix(variable, "GOPROXY=") {
+			kept = append(kept, variable)
+		}
+	}
+	return kept
+}
+
+// environ returns the variables set for `go build` on top of the runner's environment:
+// GOPROXY=off in offline mode, the cgo toggle and the manifest's extra variables. Online,
+// the user's GOPROXY applies.
+func (b BuildConfig) environ(offline bool) ([]string, error) {
+	var env []string
+	if offline {
+		env = append(env, "GOPROXY=off")
+	}
+
+	if b.CGO != nil {
+		if !*b.CGO {
+			mode, err := b.EffectiveMode()
+			if err != nil {
+				return nil, err
 			}
+			if mode != BuildModeBinary {
+				return nil, fmt.Errorf("cgo cannot be disabled when building a Go plugin (mode %s)", mode)
+			}
+			env = append(env, "CGO_ENABLED=0")
+		} else {
+			env = append(env, "CGO_ENABLED=1")
 		}
 	}
 
-	return nil
+	keys := make([]string, 0, len(b.Env))
+	for key := range b.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+b.Env[key])
+	}
+
+	return env, nil
 }
 
-func updateGoMod(pluginDir string, canonicalDeps CanonicalDeps) error {
-    goModPath := filepath.Join(pluginDir, "go.mod")
-    content, err := ioutil.ReadFile(goModPath)
-    if err != nil {
-        return err
-    }
-
-    lines := strings.Split(string(content), "\n")
-    var newLines []string
-    for _, line := range lines {
-        if strings.HasPrefix(line, "require ") {
-            parts := strings.Fields(line)
-            if len(parts) >= 3 {
-                module := parts[1]
-                if version, ok := canonicalDeps.Versions[module]; ok {
-                    newLines = append(newLines, fmt.Sprintf("require %s %s", module, version))
-                    continue
-                }
-            }
-        }
-        newLines = append(newLines, line)
-    }
-
-    return ioutil.WriteFile(goModPath, []byte(strings.Join(newLines, "\n")), 0644)
+// buildMounts returns the directories a build of the plugin in pluginDir reads or writes
+func buildMounts(pluginDir string, artifacts []Artifact) ([]string, error) {
+	mounts := []string{pluginDir}
+
+	replacements, err := localReplacements(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range replacements {
+		mounts = append(mounts, dir)
+	}
+
+	for _, artifact := range artifacts {
+		outDir := filepath.Dir(artifact.Path)
+		if !strings.HasPrefix(outDir+string(filepath.Separator), pluginDir+string(filepath.Separator)) {
+			// The output directory must exist to be mounted
+			if err := os.MkdirAll(outDir, 0755); err != nil {
+				return nil, err
+			}
+			mounts = append(mounts, outDir)
+		}
+	}
+
+	sort.Strings(mounts)
+	return mounts, nil
+}
+
+// copyFile copies src to dst unless they are the same file
+func copyFile(src, dst string) error {
+	if filepath.Clean(src) == filepath.Clean(dst) {
+		return nil
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dst, data, 0644)
 }
@@ -0,0 +1,251 @@
+package gsplug
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBuildCanceled is the error of plugins that were not built, or whose build was
+// interrupted, because an earlier build failed in fail-fast mode
+var ErrBuildCanceled = errors.New("build canceled after an earlier failure")
+
+// BuildOptions controls how BuildAll builds the plugins directory
+type BuildOptions struct {
+	// Jobs is the number of plugins built concurrently. Defaults to the number of CPUs.
+	Jobs int
+	// FailFast stops starting new builds, and cancels running ones, after the first failure.
+	// By default every plugin is built regardless of failures.
+	FailFast bool
+	// Force rebuilds plugins even if their build record shows they are up to date
+	Force bool
+	// Runner runs the go tool. Defaults to LocalRunner; see Config.HermeticRunner for
+	// builds pinned to the Gitspace host's toolchain.
+	Runner BuildRunner
+	// Toolchain decides what to do when a Go plugin would be built with a toolchain other
+	// than the Gitspace host's. Defaults to ToolchainFail.
+	Toolchain ToolchainPolicy
+	// Reproducible builds with -trimpath and an empty build ID, ignores GOFLAGS from the
+	// environment, and dates the build by $SOURCE_DATE_EPOCH or the commit time instead
+	// of the clock, so that the same commit always gives the same artifacts. A manifest
+	// can also require it with reproducible = true in [build].
+	Reproducible bool
+	// OnResult, if set, is called as each plugin finishes. Calls are never concurrent.
+	OnResult func(BuildResult)
+}
+
+// BuildResult is the outcome of building a single plugin
+type BuildResult struct {
+	// Plugin is the name of the plugin directory
+	Plugin    string
+	Dir       string
+	Duration  time.Duration
+	Artifacts []Artifact
+	// Cached is true if the plugin was up to date and not rebuilt
+	Cached bool
+	// Output is the combined stdout and stderr of the go command
+	Output []byte
+	Err    error
+}
+
+// BuildReport aggregates the results of BuildAll
+type BuildReport struct {
+	// Results holds one entry per plugin, sorted by plugin name
+	Results  []BuildResult
+	Duration time.Duration
+}
+
+// Failed returns the results of plugins that failed to build or were skipped
+func (r *BuildReport) Failed() []BuildResult {
+	var failed []BuildResult
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err returns an error naming every plugin that failed to build, or nil if all succeeded
+func (r *BuildReport) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(failed))
+	for i, result := range failed {
+		names[i] = result.Plugin
+	}
+	return fmt.Errorf("%d of %d plugins failed to build: %s", len(failed), len(r.Results), strings.Join(names, ", "))
+}
+
+// BuildAllPlugins builds all plugins in the default configuration's plugins directory
+func BuildAllPlugins() error {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.BuildAllPlugins()
+}
+
+// BuildAllPlugins builds all plugins in the Gitspace plugins directory with the default
+// options and returns an error if any of them failed
+func (c *Config) BuildAllPlugins() error {
+	report, err := c.BuildAll(context.Background(), BuildOptions{})
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// BuildAll builds every plugin in the Gitspace plugins directory concurrently. Directories
+// without a plugin manifest, and plugins installed from prebuilt packages, are ignored.
+// The returned error covers problems preparing the build; per-plugin failures are
+// reported in the BuildReport.
+func (c *Config) BuildAll(ctx context.Context, opts BuildOptions) (*BuildReport, error) {
+	start := time.Now()
+
+	pluginDirs, err := c.pluginDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	// Fetch gitspace-go.mod once rather than racing to write it from every build
+	if err := c.EnsureGitspaceModFile(); err != nil {
+		return nil, err
+	}
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan string)
+	results := make(chan BuildResult)
+
+	var workers sync.WaitGroup
+	for i := 0; i < min(jobs, len(pluginDirs)); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for dir := range queue {
+				results <- c.buildOne(ctx, dir, opts)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+		for _, dir := range pluginDirs {
+			select {
+			case queue <- dir:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	report := &BuildReport{}
+	built := make(map[string]bool, len(pluginDirs))
+	for result := range results {
+		built[result.Dir] = true
+		if result.Err != nil && opts.FailFast {
+			cancel()
+		}
+		report.Results = append(report.Results, result)
+		if opts.OnResult != nil {
+			opts.OnResult(result)
+		}
+	}
+
+	// Plugins never handed to a worker were skipped by a fail-fast cancellation
+	for _, dir := range pluginDirs {
+		if !built[dir] {
+			result := BuildResult{Plugin: filepath.Base(dir), Dir: dir, Err: ErrBuildCanceled}
+			report.Results = append(report.Results, result)
+			if opts.OnResult != nil {
+				opts.OnResult(result)
+			}
+		}
+	}
+
+	sort.Slice(report.Results, func(i, j int) bool {
+		return report.Results[i].Plugin < report.Results[j].Plugin
+	})
+	report.Duration = time.Since(start)
+
+	return report, nil
+}
+
+// buildOne builds a single plugin, capturing its output
+func (c *Config) buildOne(ctx context.Context, dir string, opts BuildOptions) BuildResult {
+	result := BuildResult{Plugin: filepath.Base(dir), Dir: dir}
+	if ctx.Err() != nil {
+		result.Err = ErrBuildCanceled
+		return result
+	}
+
+	var output bytes.Buffer
+	start := time.Now()
+	result.Artifacts, result.Cached, result.Err = c.buildPlugin(ctx, dir, opts, &output, &output)
+	result.Duration = time.Since(start)
+	result.Output = output.Bytes()
+	if result.Err != nil && ctx.Err() != nil {
+		// Killed by the cancellation, not failed on its own
+		result.Err = ErrBuildCanceled
+	}
+
+	return result
+}
+
+// pluginDirs returns the directories in the plugins directory that contain a plugin manifest,
+// except plugins installed from prebuilt packages and Install's temporary directories
+func (c *Config) pluginDirs() ([]string, error) {
+	pluginsDir := c.PluginsPath()
+
+	entries, err := os.ReadDir(pluginsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugins directory: %w", err)
+	}
+
+	registry, err := c.ReadRegistry()
+	if err != nil {
+		return nil, err
+	}
+	prebuilt := make(map[string]bool)
+	for _, installed := range registry.Plugins {
+		if installed.Kind == InstallFromPackage {
+			prebuilt[installed.Dir] = true
+		}
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || prebuilt[entry.Name()] {
+			continue
+		}
+		dir := filepath.Join(pluginsDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, ManifestFileName)); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs, nil
+}
@@ -0,0 +1,315 @@
+package gsplug
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BuildRecordFile is written next to a plugin's artifacts after a successful build
+const BuildRecordFile = ".gsplug-build.json"
+
+// buildEnvVars are the go env settings that affect the compiled output
+var buildEnvVars = []string{"GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS", "GOEXPERIMENT", "GOAMD64", "GOARM", "GOARM64"}
+
+// BuildRecord describes the inputs of the last successful build of a plugin
+type BuildRecord struct {
+	// Fingerprint is a SHA-256 of every build input, see buildFingerprint
+	Fingerprint string `json:"fingerprint"`
+	// Toolchain is the GOVERSION of the toolchain that built the artifacts
+	Toolchain string `json:"toolchain"`
+	// HostToolchain is the Gitspace toolchain a Go plugin was checked against
+	HostToolchain string `json:"host_toolchain,omitempty"`
+	// Platform is the GOOS/GOARCH the artifacts were built for
+	Platform string `json:"platform"`
+	// Runner is the name of the BuildRunner that ran the go tool
+	Runner string `json:"runner"`
+	// Reproducible is set for builds in reproducible mode, whose BuiltAt is the source date
+	Reproducible bool `json:"reproducible,omitempty"`
+	// Artifacts are the paths of the artifacts, relative to the plugin directory when they
+	// are inside it so that the record survives moving the plugin
+	Artifacts []string  `json:"artifacts"`
+	BuiltAt   time.Time `json:"built_at"`
+}
+
+// buildRecordPath returns where the build record of the given artifacts is stored
+func buildRecordPath(artifacts []Artifact) string {
+	return filepath.Join(filepath.Dir(artifacts[0].Path), BuildRecordFile)
+}
+
+// readBuildRecord reads the build record of the given artifacts of the plugin in pluginDir,
+// resolving the recorded artifact paths against pluginDir
+func readBuildRecord(pluginDir string, artifacts []Artifact) (*BuildRecord, error) {
+	data, err := os.ReadFile(buildRecordPath(artifacts))
+	if err != nil {
+		return nil, err
+	}
+
+	var record BuildRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	for i, path := range record.Artifacts {
+		if !filepath.IsAbs(path) {
+			record.Artifacts[i] = filepath.Join(pluginDir, path)
+		}
+	}
+
+	return &record, nil
+}
+
+// recordedArtifactPath returns the path of an artifact as stored in the build record of
+// the plugin in pluginDir
+func recordedArtifactPath(pluginDir, path string) string {
+	rel, err := filepath.Rel(pluginDir, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return path
+	}
+	return rel
+}
+
+// writeBuildRecord stores the build record of the given artifacts
+func writeBuildRecord(artifacts []Artifact, record *BuildRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(buildRecordPath(artifacts), data, 0644)
+}
+
+// upToDate reports whether the artifacts of the plugin in pluginDir exist and were built
+// from inputs with the given fingerprint
+func upToDate(pluginDir string, artifacts []Artifact, fingerprint string) bool {
+	record, err := readBuildRecord(pluginDir, artifacts)
+	if err != nil || record.Fingerprint != fingerprint {
+		return false
+	}
+	// Records written before the platform was recorded cannot be packaged
+	if record.Platform == "" {
+		return false
+	}
+
+	for _, artifact := range artifacts {
+		if _, err := os.Stat(artifact.Path); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// buildFingerprint hashes everything that determines the output of building the plugin:
+// the plugin module's files (including go.mod, go.sum and the manifest), the source of
+// modules it replaces with local directories, the canonical dependency versions,
+// Gitspace's go.mod, the go build arguments, and the toolchain and platform settings
+// reported by `go env`. It returns the fingerprint, the Go version and the GOOS/GOARCH
+// platform.
+func (c *Config) buildFingerprint(ctx context.Context, runner BuildRunner, cmd GoCommand, artifacts []Artifact, buildArgs [][]string, canonical *CanonicalDeps) (fingerprint, toolchain, platform string, err error) {
+	h := sha256.New()
+	pluginDir := cmd.Dir
+
+	root, err := filepath.Abs(pluginDir)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// Artifacts, their provenance and signatures, and the output directories holding them
+	// are not inputs
+	exclude := make(map[string]bool)
+	for _, artifact := range artifacts {
+		path, err := filepath.Abs(artifact.Path)
+		if err != nil {
+			return "", "", "", err
+		}
+		exclude[path] = true
+		exclude[path+ProvenanceExt] = true
+		exclude[path+SignatureExt] = true
+		if outDir := filepath.Dir(path); outDir != root {
+			exclude[outDir] = true
+		}
+	}
+
+	if err := hashModuleDir(h, "plugin", pluginDir, exclude); err != nil {
+		return "", "", "", err
+	}
+
+	replacements, err := localReplacements(pluginDir)
+	if err != nil {
+		return "", "", "", err
+	}
+	modules := make([]string, 0, len(replacements))
+	for module := range replacements {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		if err := hashModuleDir(h, "replace "+module, replacements[module], exclude); err != nil {
+			return "", "", "", fmt.Errorf("failed to fingerprint replacement of %s: %w", module, err)
+		}
+	}
+
+	canonicalJSON, err := json.Marshal(canonical.Versions)
+	if err != nil {
+		return "", "", "", err
+	}
+	fmt.Fprintf(h, "canonical %s\n", canonicalJSON)
+
+	gitspaceMod, err := os.ReadFile(c.GitspaceModPath())
+	if err != nil {
+		return "", "", "", err
+	}
+	fmt.Fprintf(h, "gitspace %x\n", sha256.Sum256(gitspaceMod))
+
+	for _, args := range buildArgs {
+		fmt.Fprintf(h, "args %q\n", relativeOutputArgs(args, root))
+	}
+
+	goEnv, err := goEnvironment(ctx, runner, cmd)
+	if err != nil {
+		return "", "", "", err
+	}
+	fmt.Fprintf(h, "runner %s\nenv %s\n", runner.Name(), goEnv)
+
+	// goEnvironment reports GOVERSION, GOOS and GOARCH first
+	values := strings.Split(goEnv, "\n")
+	if len(values) < 3 {
+		return "", "", "", fmt.Errorf("unexpected go env output %q", goEnv)
+	}
+	return hex.EncodeToString(h.Sum(nil)), values[0], values[1] + "/" + values[2], nil
+}
+
+// hashModuleDir writes the path and content hash of every file of the module rooted at dir
+// to h, in a stable order. Like the go command, it skips nested modules, testdata and
+// files and directories starting with "." or "_".
+func hashModuleDir(h hash.Hash, label, dir string, exclude map[string]bool) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if exclude[path] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path == dir {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if name == "testdata" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+	fmt.Fprintf(h, "module %s\n", label)
+	for _, path := range files {
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %s\n", sum, filepath.ToSlash(rel))
+	}
+
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// localReplacements returns the absolute directory of every module the plugin in
+// pluginDir replaces with a local directory, keyed by module path
+func localReplacements(pluginDir string) (map[string]string, error) {
+	f, _, err := readModFile(filepath.Join(pluginDir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make(map[string]string)
+	for _, r := range f.Replace {
+		if r.New.Version != "" {
+			continue
+		}
+		dir := r.New.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(pluginDir, dir)
+		}
+		if dirs[r.Old.Path], err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return dirs, nil
+}
+
+// goEnvironment returns the values of buildEnvVars, one per line starting with GOVERSION,
+// as the runner's go command sees them when running goCmd
+func goEnvironment(ctx context.Context, runner BuildRunner, goCmd GoCommand) (string, error) {
+	var stderr bytes.Buffer
+	goCmd.Args = append([]string{"env"}, buildEnvVars...)
+	cmd, err := runner.Command(ctx, goCmd)
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go env: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
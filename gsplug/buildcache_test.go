@@ -0,0 +1,54 @@
+package gsplug
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildUpToDate(t *testing.T) {
+	tests := []struct {
+		name  string
+		build string
+	}{
+		{name: "default output", build: "mode = \"binary\"\n"},
+		{name: "output in the plugin root", build: "binary = \"hello\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			dir := t.TempDir()
+			writeTestPlugin(t, dir, tt.build)
+			build := func() bool {
+				t.Helper()
+				artifacts, cached, err := cfg.buildPlugin(context.Background(), dir, BuildOptions{}, io.Discard, io.Discard)
+				if err != nil {
+					t.Fatalf("build: %v", err)
+				}
+				for _, artifact := range artifacts {
+					if _, err := os.Stat(artifact.Path + ProvenanceExt); err != nil {
+						t.Errorf("provenance: %v", err)
+					}
+					// Signing happens after the build and must not make the plugin out of date
+					writeTestFile(t, artifact.Path+SignatureExt, "signature")
+				}
+				return cached
+			}
+
+			if build() {
+				t.Fatal("first build was cached")
+			}
+			if !build() {
+				t.Error("second build was not cached")
+			}
+
+			writeTestFile(t, filepath.Join(dir, "extra.go"), "package main\n")
+			if build() {
+				t.Error("build after changing a source was cached")
+			}
+		})
+	}
+}
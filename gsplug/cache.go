@@ -0,0 +1,220 @@
+package gsplug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fetchTimeout bounds every request made to GitHub
+const fetchTimeout = 30 * time.Second
+
+// Names of the artifacts kept in the download cache
+const (
+	CacheGitspaceMod     = "gitspace-go.mod"
+	CacheGitspaceSum     = "gitspace-go.sum"
+	CacheGitspaceRelease = "gitspace-release.json"
+)
+
+// cacheSources maps every cacheable artifact to the URL it is downloaded from
+var cacheSources = map[string]string{
+	CacheGitspaceMod:     GitspaceRepoURL,
+	CacheGitspaceSum:     GitspaceSumURL,
+	CacheGitspaceRelease: GitspaceVersionURL,
+}
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+// CacheEntry describes an artifact in the download cache
+type CacheEntry struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	ETag      string    `json:"etag,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"`
+	// Source is the local file the entry was seeded from, if it was not downloaded
+	Source string `json:"source,omitempty"`
+}
+
+// SeedCache stores a local file in the default configuration's download cache
+func SeedCache(name, path string) error {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.SeedCache(name, path)
+}
+
+// SeedCache stores the content of a local file as the cached copy of the named artifact.
+// This lets air-gapped machines work offline without ever reaching GitHub.
+func (c *Config) SeedCache(name, path string) error {
+	url, ok := cacheSources[name]
+	if !ok {
+		return fmt.Errorf("unknown cache entry %q, expected one of %s", name, strings.Join(CacheNames(), ", "))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	return c.writeCacheEntry(CacheEntry{Name: name, URL: url, FetchedAt: time.Now().UTC(), Source: absPath}, data)
+}
+
+// CacheNames returns the names of all cacheable artifacts
+func CacheNames() []string {
+	names := make([]string, 0, len(cacheSources))
+	for name := range cacheSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CacheEntries returns the metadata of every artifact in the default configuration's cache
+func CacheEntries() ([]CacheEntry, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.CacheEntries()
+}
+
+// CacheEntries returns the metadata of every cached artifact
+func (c *Config) CacheEntries() ([]CacheEntry, error) {
+	var entries []CacheEntry
+	for _, name := range CacheNames() {
+		entry, _, err := c.readCacheEntry(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// fetchCached returns the named artifact. Online, it is downloaded (revalidated with its
+// ETag when cached) and the cache is refreshed; if the download fails the cached copy is
+// used. Offline, only the cache is consulted.
+func (c *Config) fetchCached(name string) ([]byte, error) {
+	url := cacheSources[name]
+	entry, cached, cacheErr := c.readCacheEntry(name)
+	hasCache := cacheErr == nil
+
+	if c.Offline {
+		if !hasCache {
+			return nil, fmt.Errorf("offline mode: %s is not cached; seed it with `gsplug cache seed %s <file>`", name, name)
+		}
+		return cached, nil
+	}
+
+	// Revalidate only a complete entry: metadata whose data file is gone must not turn a
+	// 304 into an empty artifact
+	etag := ""
+	if hasCache {
+		etag = entry.ETag
+	}
+	data, newEntry, err := download(url, etag)
+	if err != nil {
+		if hasCache {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	if data == nil {
+		// Not modified
+		data = cached
+		newEntry.ETag = entry.ETag
+	}
+	newEntry.Name = name
+	if err := c.writeCacheEntry(newEntry, data); err != nil {
+		return nil, fmt.Errorf("failed to update cache: %w", err)
+	}
+
+	return data, nil
+}
+
+// download fetches url, sending etag for revalidation. It returns nil data if the server
+// reports the content as not modified.
+func download(url, etag string) ([]byte, CacheEntry, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, CacheEntry{}, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, CacheEntry{}, err
+	}
+	defer resp.Body.Close()
+
+	entry := CacheEntry{URL: url, ETag: resp.Header.Get("ETag"), FetchedAt: time.Now().UTC()}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, entry, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, CacheEntry{}, fmt.Errorf("GET %s: unexpected status %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, CacheEntry{}, err
+	}
+
+	return data, entry, nil
+}
+
+func (c *Config) readCacheEntry(name string) (CacheEntry, []byte, error) {
+	var entry CacheEntry
+
+	meta, err := os.ReadFile(filepath.Join(c.CacheDir(), name+".json"))
+	if err != nil {
+		return entry, nil, err
+	}
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return entry, nil, fmt.Errorf("corrupt cache metadata for %s: %w", name, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.CacheDir(), name))
+	if err != nil {
+		return entry, nil, err
+	}
+
+	return entry, data, nil
+}
+
+func (c *Config) writeCacheEntry(entry CacheEntry, data []byte) error {
+	dir := c.CacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	meta, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, entry.Name), data, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, entry.Name+".json"), meta, 0644)
+}
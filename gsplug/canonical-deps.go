@@ -3,8 +3,6 @@ package gsplug
 import (
 	"encoding/json"
 	"io/ioutil"
-	"os"
-	"path/filepath"
 )
 
 type CanonicalDeps struct {
@@ -12,13 +10,15 @@ type CanonicalDeps struct {
 }
 
 func GetCanonicalDeps() (CanonicalDeps, error) {
-	homeDir, err := os.UserHomeDir()
+	cfg, err := DefaultConfig()
 	if err != nil {
 		return CanonicalDeps{}, err
 	}
+	return cfg.GetCanonicalDeps()
+}
 
-	depsPath := filepath.Join(homeDir, ".ssot", "gitspace", "canonical-deps.json")
-	data, err := ioutil.ReadFile(depsPath)
+func (c *Config) GetCanonicalDeps() (CanonicalDeps, error) {
+	data, err := ioutil.ReadFile(c.CanonicalDepsPath())
 	if err != nil {
 		return CanonicalDeps{}, err
 	}
@@ -3,8 +3,6 @@ package gsplug
 import (
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,15 +15,19 @@ const (
 	VersionFile        = "gitspace-version.json"
 )
 
-// FetchLatestGitspaceVersion fetches the latest Gitspace version from GitHub
+// FetchLatestGitspaceVersion fetches the latest Gitspace version using the default configuration
 func FetchLatestGitspaceVersion() (string, error) {
-	resp, err := http.Get(GitspaceVersionURL)
+	cfg, err := DefaultConfig()
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
+	return cfg.FetchLatestGitspaceVersion()
+}
 
-	body, err := io.ReadAll(resp.Body)
+// FetchLatestGitspaceVersion fetches the latest Gitspace version from GitHub,
+// falling back to the cached release metadata when offline or unreachable
+func (c *Config) FetchLatestGitspaceVersion() (string, error) {
+	body, err := c.fetchCached(CacheGitspaceRelease)
 	if err != nil {
 		return "", err
 	}
@@ -43,16 +45,25 @@ func FetchLatestGitspaceVersion() (string, error) {
 	return strings.TrimPrefix(version, "v"), nil
 }
 
-// UpdateVersionFile updates the local version file with the latest Gitspace version
+// UpdateVersionFile updates the default configuration's version file
 func UpdateVersionFile() error {
-	version, err := FetchLatestGitspaceVersion()
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.UpdateVersionFile()
+}
+
+// UpdateVersionFile updates the local version file with the latest Gitspace version
+func (c *Config) UpdateVersionFile() error {
+	version, err := c.FetchLatestGitspaceVersion()
 	if err != nil {
 		return err
 	}
 
 	versionInfo := VersionInfo{
-		GitspaceVersion: version,
-		PluginAPIVersion: "1.0.0", // This should be updated manually when the plugin API changes
+		GitspaceVersion:  version,
+		PluginAPIVersion: PluginAPIVersion,
 	}
 
 	data, err := json.MarshalIndent(versionInfo, "", "  ")
@@ -60,14 +71,25 @@ func UpdateVersionFile() error {
 		return err
 	}
 
-	versionFilePath := filepath.Join(os.Getenv("HOME"), ".ssot", "gitspace", VersionFile)
+	versionFilePath := c.VersionFilePath()
+	if err := os.MkdirAll(filepath.Dir(versionFilePath), 0755); err != nil {
+		return err
+	}
 	return os.WriteFile(versionFilePath, data, 0644)
 }
 
-// GetVersionInfo reads the version info from the local version file
+// GetVersionInfo reads the version info from the default configuration's version file
 func GetVersionInfo() (*VersionInfo, error) {
-	versionFilePath := filepath.Join(os.Getenv("HOME"), ".ssot", "gitspace", VersionFile)
-	data, err := os.ReadFile(versionFilePath)
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.GetVersionInfo()
+}
+
+// GetVersionInfo reads the version info from the local version file
+func (c *Config) GetVersionInfo() (*VersionInfo, error) {
+	data, err := os.ReadFile(c.VersionFilePath())
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +102,27 @@ func GetVersionInfo() (*VersionInfo, error) {
 	return &versionInfo, nil
 }
 
+// Constraint names reported by CompatibilityError
+const (
+	ConstraintGitspace  = "gitspace"
+	ConstraintPluginAPI = "plugin_api"
+)
+
+// CompatibilityError is returned when the host does not satisfy one of the manifest's constraints
+type CompatibilityError struct {
+	// Constraint is ConstraintGitspace or ConstraintPluginAPI
+	Constraint string
+	Required   string
+	Actual     string
+}
+
+func (e *CompatibilityError) Error() string {
+	return fmt.Sprintf("%s version %s does not satisfy the plugin's %s constraint %q", e.Constraint, e.Actual, e.Constraint, e.Required)
+}
+
 // CheckCompatibility checks if the plugin is compatible with the current Gitspace version
+//
+// Deprecated: Use CheckManifestCompatibility, which also checks the plugin API constraint.
 func CheckCompatibility(pluginVersion string) (bool, error) {
 	versionInfo, err := GetVersionInfo()
 	if err != nil {
@@ -99,3 +141,55 @@ func CheckCompatibility(pluginVersion string) (bool, error) {
 
 	return pluginConstraint.Check(gitspaceVersion), nil
 }
+
+// CheckManifestCompatibility checks the manifest's constraints using the default configuration
+func CheckManifestCompatibility(manifest *PluginManifest) error {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.CheckManifestCompatibility(manifest)
+}
+
+// CheckManifestCompatibility checks the manifest's [compatibility] constraints: plugin_api
+// against the PluginAPIVersion this binary implements, and gitspace against the version
+// file, which is only read if the manifest has a gitspace constraint. It returns a
+// *CompatibilityError naming the first constraint that is not satisfied.
+func (c *Config) CheckManifestCompatibility(manifest *PluginManifest) error {
+	if err := checkConstraint(ConstraintPluginAPI, manifest.Compatibility.PluginAPI, PluginAPIVersion); err != nil {
+		return err
+	}
+
+	if manifest.Compatibility.Gitspace == "" {
+		return nil
+	}
+	versionInfo, err := c.GetVersionInfo()
+	if err != nil {
+		return err
+	}
+
+	return checkConstraint(ConstraintGitspace, manifest.Compatibility.Gitspace, versionInfo.GitspaceVersion)
+}
+
+// checkConstraint checks a single semver constraint, treating an empty constraint as satisfied
+func checkConstraint(name, constraint, actual string) error {
+	if constraint == "" {
+		return nil
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return fmt.Errorf("invalid %s constraint %q: %w", name, constraint, err)
+	}
+
+	v, err := semver.NewVersion(actual)
+	if err != nil {
+		return fmt.Errorf("invalid %s version %q: %w", name, actual, err)
+	}
+
+	if !c.Check(v) {
+		return &CompatibilityError{Constraint: name, Required: constraint, Actual: actual}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package gsplug
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckManifestCompatibility(t *testing.T) {
+	tests := []struct {
+		name        string
+		versionFile string
+		gitspace    string
+		pluginAPI   string
+		// wantConstraint is the constraint reported by a *CompatibilityError, or empty
+		wantConstraint string
+		wantErr        bool
+	}{
+		{name: "no constraints and no version file"},
+		{name: "plugin API satisfied without a version file", pluginAPI: "^1.0"},
+		{name: "plugin API not satisfied", pluginAPI: ">= 2.0", wantConstraint: ConstraintPluginAPI},
+		{
+			name:        "plugin API ignores a stale version file",
+			versionFile: `{"gitspace_version": "0.5.0", "plugin_api_version": "0.1.0"}`,
+			pluginAPI:   "^1.0",
+		},
+		{
+			name:        "gitspace satisfied",
+			versionFile: `{"gitspace_version": "0.5.0", "plugin_api_version": "1.0.0"}`,
+			gitspace:    ">= 0.5.0",
+		},
+		{
+			name:           "gitspace not satisfied",
+			versionFile:    `{"gitspace_version": "0.4.0", "plugin_api_version": "1.0.0"}`,
+			gitspace:       ">= 0.5.0",
+			wantConstraint: ConstraintGitspace,
+		},
+		{name: "gitspace without a version file", gitspace: ">= 0.5.0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Home: t.TempDir()}
+			if tt.versionFile != "" {
+				if err := os.WriteFile(filepath.Join(cfg.Home, VersionFile), []byte(tt.versionFile), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			manifest := &PluginManifest{}
+			manifest.Compatibility.Gitspace = tt.gitspace
+			manifest.Compatibility.PluginAPI = tt.pluginAPI
+
+			err := cfg.CheckManifestCompatibility(manifest)
+
+			var compatErr *CompatibilityError
+			switch {
+			case tt.wantConstraint != "":
+				if !errors.As(err, &compatErr) || compatErr.Constraint != tt.wantConstraint {
+					t.Errorf("CheckManifestCompatibility() = %v, want a %s CompatibilityError", err, tt.wantConstraint)
+				}
+			case tt.wantErr:
+				if err == nil || errors.As(err, &compatErr) {
+					t.Errorf("CheckManifestCompatibility() = %v, want an error reading the version file", err)
+				}
+			case err != nil:
+				t.Errorf("CheckManifestCompatibility() = %v, want nil", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package gsplug
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Environment variables read by LoadConfig
+const (
+	// HomeEnv overrides the Gitspace home directory
+	HomeEnv = "GITSPACE_HOME"
+	// PluginsDirEnv overrides the plugins directory
+	PluginsDirEnv = "GITSPACE_PLUGINS_DIR"
+	// ConfigFileEnv overrides the location of the gsplug config file
+	ConfigFileEnv = "GSPLUG_CONFIG"
+	// OfflineEnv enables offline mode when set to a true value (1, true, yes)
+	OfflineEnv = "GSPLUG_OFFLINE"
+	// RequireSignaturesEnv makes signatures mandatory when set to a true value
+	RequireSignaturesEnv = "GSPLUG_REQUIRE_SIGNATURES"
+)
+
+// Config locates Gitspace's files and controls network access. Every gsplug
+// operation is available as a method on Config; the package-level functions use
+// DefaultConfig. The zero value is usable: unset fields fall back to defaults.
+type Config struct {
+	// Home is the Gitspace home directory holding the version file, canonical
+	// dependencies and download cache. Defaults to ~/.ssot/gitspace.
+	Home string `toml:"home"`
+	// PluginsDir holds installed plugins and gitspace-go.mod. Defaults to <Home>/plugins.
+	PluginsDir string `toml:"plugins_dir"`
+	// Offline disables all network access: downloads are served from the cache
+	// and the go command runs with GOPROXY=off.
+	Offline bool `toml:"offline"`
+	// RequireSignatures refuses to install packages whose artifacts are not signed by
+	// a trusted key. Signatures that are present are always verified.
+	RequireSignatures bool `toml:"require_signatures"`
+}
+
+// LoadConfig resolves the configuration from, in increasing order of precedence,
+// built-in defaults, the config file and environment variables. If configPath is
+// empty, $GSPLUG_CONFIG or <user config dir>/gsplug/config.toml is used; a missing
+// default config file is not an error.
+func LoadConfig(configPath string) (*Config, error) {
+	cfg := &Config{}
+
+	explicit := configPath != ""
+	if !explicit {
+		configPath = os.Getenv(ConfigFileEnv)
+		explicit = configPath != ""
+	}
+	if !explicit {
+		if dir, err := os.UserConfigDir(); err == nil {
+			configPath = filepath.Join(dir, "gsplug", "config.toml")
+		}
+	}
+
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		switch {
+		case err == nil:
+			if err := toml.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+			}
+		case errors.Is(err, os.ErrNotExist) && !explicit:
+		default:
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	if home := os.Getenv(HomeEnv); home != "" {
+		cfg.Home = home
+	}
+	if pluginsDir := os.Getenv(PluginsDirEnv); pluginsDir != "" {
+		cfg.PluginsDir = pluginsDir
+	}
+	cfg.Offline = cfg.Offline || envBool(OfflineEnv)
+	cfg.RequireSignatures = cfg.RequireSignatures || envBool(RequireSignaturesEnv)
+
+	if cfg.Home == "" {
+		cfg.Home = defaultHome()
+	}
+	cfg.Home = expandHome(cfg.Home)
+	// An unset PluginsDir follows Home, so it stays inside a home overridden later
+	cfg.PluginsDir = expandHome(cfg.PluginsDir)
+
+	return cfg, nil
+}
+
+// envBool reports whether the environment variable is set to a true value (1, true, yes)
+func envBool(name string) bool {
+	value := os.Getenv(name)
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return strings.EqualFold(value, "yes")
+	}
+	return enabled
+}
+
+// DefaultConfig returns the configuration used by the package-level functions
+func DefaultConfig() (*Config, error) {
+	return LoadConfig("")
+}
+
+// defaultHome returns ~/.ssot/gitspace, or $XDG_DATA_HOME/gitspace when that is
+// set and the legacy directory does not exist yet
+func defaultHome() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = os.Getenv("HOME")
+	}
+	legacy := filepath.Join(homeDir, ".ssot", "gitspace")
+
+	if dataHome := os.Getenv("XDG_DATA_HOME"); dataHome != "" {
+		if _, err := os.Stat(legacy); os.IsNotExist(err) {
+			return filepath.Join(dataHome, "gitspace")
+		}
+	}
+
+	return legacy
+}
+
+// expandHome expands a leading ~ to the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+}
+
+// HomeDir returns the Gitspace home directory
+func (c *Config) HomeDir() string {
+	if c.Home == "" {
+		return defaultHome()
+	}
+	return c.Home
+}
+
+// PluginsPath returns the directory holding installed plugins
+func (c *Config) PluginsPath() string {
+	if c.PluginsDir == "" {
+		return filepath.Join(c.HomeDir(), "plugins")
+	}
+	return c.PluginsDir
+}
+
+// GitspaceModPath returns the path of the local copy of Gitspace's go.mod
+func (c *Config) GitspaceModPath() string {
+	return filepath.Join(c.PluginsPath(), "gitspace-go.mod")
+}
+
+// GitspaceSumPath returns the path of the local copy of Gitspace's go.sum
+func (c *Config) GitspaceSumPath() string {
+	return filepath.Join(c.PluginsPath(), "gitspace-go.sum")
+}
+
+// CanonicalDepsPath returns the path of canonical-deps.json
+func (c *Config) CanonicalDepsPath() string {
+	return filepath.Join(c.HomeDir(), "canonical-deps.json")
+}
+
+// VersionFilePath returns the path of the Gitspace version file
+func (c *Config) VersionFilePath() string {
+	return filepath.Join(c.HomeDir(), VersionFile)
+}
+
+// CacheDir returns the directory holding cached downloads
+func (c *Config) CacheDir() string {
+	return filepath.Join(c.HomeDir(), "cache")
+}
+
+// RegistryPath returns the path of the registry of installed plugins
+func (c *Config) RegistryPath() string {
+	return filepath.Join(c.PluginsPath(), RegistryFileName)
+}
+
+// TrustedKeysPath returns the path of the file listing the public keys trusted to sign plugins
+func (c *Config) TrustedKeysPath() string {
+	return filepath.Join(c.HomeDir(), "trusted-keys")
+}
+
+// SigningKeyPath returns the default location of the private key used to sign plugins
+func (c *Config) SigningKeyPath() string {
+	return filepath.Join(c.HomeDir(), "signing.key")
+}
@@ -0,0 +1,260 @@
+package gsplug
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ModuleConflict is a module selected at different versions in the plugin's and Gitspace's
+// module graphs. A plugin built with such a conflict fails to load with "plugin was built
+// with a different version of package".
+type ModuleConflict struct {
+	Module        string `json:"module"`
+	PluginVersion string `json:"plugin_version"`
+	HostVersion   string `json:"host_version"`
+	// PluginPath and HostPath are the requirement chains, starting at each main module,
+	// through which the conflicting versions were introduced
+	PluginPath []string `json:"plugin_path"`
+	HostPath   []string `json:"host_path"`
+}
+
+// AnalyzeConflicts analyzes the plugin in pluginDir using the default configuration
+func AnalyzeConflicts(pluginDir string) ([]ModuleConflict, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.AnalyzeConflicts(pluginDir)
+}
+
+// AnalyzeConflicts compares the full module graph of the plugin in pluginDir with that of
+// the Gitspace host (from gitspace-go.mod) and returns every module whose selected version
+// differs, sorted by module path. Replacements are taken into account.
+func (c *Config) AnalyzeConflicts(pluginDir string) ([]ModuleConflict, error) {
+	if err := c.EnsureGitspaceModFile(); err != nil {
+		return nil, err
+	}
+
+	// Never let the go command rewrite the plugin's go.mod or go.sum
+	pluginGraph, err := c.loadModuleGraph(pluginDir, "readonly")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugin module graph: %w", err)
+	}
+
+	hostDir, err := os.MkdirTemp("", "gsplug-host-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(hostDir)
+
+	if err := c.prepareHostModule(hostDir); err != nil {
+		return nil, fmt.Errorf("failed to prepare Gitspace module: %w", err)
+	}
+
+	hostGraph, err := c.loadModuleGraph(hostDir, "mod")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Gitspace module graph: %w", err)
+	}
+
+	var conflicts []ModuleConflict
+	for module, pluginMod := range pluginGraph.modules {
+		hostMod, ok := hostGraph.modules[module]
+		if !ok || pluginMod.effective() == hostMod.effective() {
+			continue
+		}
+
+		conflicts = append(conflicts, ModuleConflict{
+			Module:        module,
+			PluginVersion: pluginMod.effective(),
+			HostVersion:   hostMod.effective(),
+			PluginPath:    pluginGraph.requirementPath(module),
+			HostPath:      hostGraph.requirementPath(module),
+		})
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Module < conflicts[j].Module
+	})
+
+	return conflicts, nil
+}
+
+// prepareHostModule writes Gitspace's go.mod and go.sum to dir so that its module graph
+// can be loaded there. Local replacements with a relative path are resolved against the
+// Gitspace checkout gitspace-go.mod was seeded from; without one they cannot be resolved.
+func (c *Config) prepareHostModule(dir string) error {
+	if err := c.ensureGitspaceSumFile(); err != nil {
+		return err
+	}
+	if err := copyFile(c.GitspaceSumPath(), filepath.Join(dir, "go.sum")); err != nil {
+		return err
+	}
+
+	f, _, err := readModFile(c.GitspaceModPath())
+	if err != nil {
+		return err
+	}
+
+	root := ""
+	if entry, _, err := c.readCacheEntry(CacheGitspaceMod); err == nil && entry.Source != "" {
+		root = filepath.Dir(entry.Source)
+	}
+
+	for _, r := range f.Replace {
+		if r.New.Version != "" || filepath.IsAbs(r.New.Path) {
+			continue
+		}
+		if root == "" {
+			return fmt.Errorf("gitspace-go.mod replaces %s with the relative directory %s, which cannot be resolved; seed it from a Gitspace checkout with `gsplug cache seed %s <checkout>/go.mod`",
+				r.Old.Path, r.New.Path, CacheGitspaceMod)
+		}
+		if err := f.AddReplace(r.Old.Path, r.Old.Version, filepath.Join(root, r.New.Path), ""); err != nil {
+			return err
+		}
+	}
+
+	data, err := f.Format()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "go.mod"), data, 0644)
+}
+
+// listedModule is the subset of `go list -m -json` output used for conflict analysis
+type listedModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Replace *listedModule
+}
+
+// effective returns the version that is actually built, accounting for replacements
+func (m *listedModule) effective() string {
+	if m.Replace == nil {
+		return m.Version
+	}
+	if m.Replace.Version == "" {
+		// Replaced by a local directory
+		return "=> " + m.Replace.Path
+	}
+	if m.Replace.Path == m.Path {
+		return m.Replace.Version
+	}
+	return "=> " + m.Replace.Path + "@" + m.Replace.Version
+}
+
+// moduleGraph is the resolved module graph of a main module
+type moduleGraph struct {
+	main string
+	// modules holds the selected version of every non-main module
+	modules map[string]*listedModule
+	// requires maps a graph node ("path" for the main module, "path@version" otherwise) to its requirements
+	requires map[string][]string
+}
+
+// loadModuleGraph resolves the module graph of the main module in dir using the given -mod mode
+func (c *Config) loadModuleGraph(dir, modMode string) (*moduleGraph, error) {
+	g := &moduleGraph{
+		modules:  make(map[string]*listedModule),
+		requires: make(map[string][]string),
+	}
+
+	out, err := c.goCommand(dir, modMode, "list", "-m", "-json", "all")
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m listedModule
+		if err := decoder.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go list output: %w", err)
+		}
+		if m.Main {
+			g.main = m.Path
+			continue
+		}
+		mod := m
+		g.modules[m.Path] = &mod
+	}
+
+	out, err = c.goCommand(dir, modMode, "mod", "graph")
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		g.requires[fields[0]] = append(g.requires[fields[0]], fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// requirementPath returns the shortest requirement chain from the main module to the
+// selected version of module
+func (g *moduleGraph) requirementPath(module string) []string {
+	m, ok := g.modules[module]
+	if !ok {
+		return nil
+	}
+	target := module + "@" + m.Version
+
+	parent := map[string]string{g.main: ""}
+	queue := []string{g.main}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		if node == target {
+			var path []string
+			for n := node; n != ""; n = parent[n] {
+				path = append([]string{n}, path...)
+			}
+			return path
+		}
+
+		for _, next := range g.requires[node] {
+			if _, seen := parent[next]; !seen {
+				parent[next] = node
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return []string{g.main, target}
+}
+
+// goCommand runs the go tool in dir with the given -mod mode, without consulting a workspace
+func (c *Config) goCommand(dir, modMode string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=-mod="+modMode)
+	if c.Offline {
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,140 @@
+package gsplug
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// PluginContext is what the host hands to plugins that implement ContextInitializer or
+// ContextRunner. It embeds the context.Context of the call, which is canceled when the host
+// no longer needs the call to finish, for example because Gitspace is exiting.
+type PluginContext struct {
+	context.Context
+	// Manifest is the plugin's gitspace-plugin.toml
+	Manifest *PluginManifest
+	// Dir is the directory the plugin is installed in
+	Dir string
+	// DataDir is where the plugin keeps data it needs across runs, and CacheDir where it
+	// keeps data it can recreate. Both exist when the plugin is called.
+	DataDir  string
+	CacheDir string
+	// Logger is a structured logger whose records name the plugin
+	Logger *slog.Logger
+	// VersionInfo is the Gitspace and plugin API version of the host. GitspaceVersion is
+	// empty if the host does not know it.
+	VersionInfo *VersionInfo
+	// Config holds the plugin's settings, declared by the [[config]] entries of its manifest
+	Config *PluginConfig
+
+	services map[string]any
+}
+
+// ContextInitializer is implemented by plugins that want a PluginContext when they are
+// initialized. Hosts call InitContext instead of Init.
+type ContextInitializer interface {
+	InitContext(pc *PluginContext) error
+}
+
+// ContextRunner is implemented by plugins that want a PluginContext when they run. Hosts
+// call RunContext instead of Run.
+type ContextRunner interface {
+	RunContext(pc *PluginContext) error
+}
+
+// Service returns the host service registered under name. Hosts register services with
+// ManagerOptions.Services. They are only available to plugins loaded in-process.
+func (pc *PluginContext) Service(name string) (any, bool) {
+	service, ok := pc.services[name]
+	return service, ok
+}
+
+// WithContext returns a copy of pc that carries ctx
+func (pc *PluginContext) WithContext(ctx context.Context) *PluginContext {
+	copied := *pc
+	copied.Context = ctx
+	return &copied
+}
+
+// InitPlugin initializes p with InitContext if it is a ContextInitializer, and with Init
+// otherwise
+func InitPlugin(p Plugin, pc *PluginContext) error {
+	if initializer, ok := p.(ContextInitializer); ok && pc != nil {
+		return initializer.InitContext(pc)
+	}
+	return p.Init()
+}
+
+// RunPlugin runs p with RunContext if it is a ContextRunner, and with Run otherwise
+func RunPlugin(p Plugin, pc *PluginContext) error {
+	if runner, ok := p.(ContextRunner); ok && pc != nil {
+		return runner.RunContext(pc)
+	}
+	return p.Run()
+}
+
+// PluginDataDir returns the directory where the named plugin keeps its data
+func (c *Config) PluginDataDir(name string) string {
+	return filepath.Join(c.HomeDir(), "plugin-data", name)
+}
+
+// PluginCacheDir returns the directory where the named plugin keeps its cache
+func (c *Config) PluginCacheDir(name string) string {
+	return filepath.Join(c.CacheDir(), "plugins", name)
+}
+
+// NewPluginContext returns a context for running a plugin standalone, using the default
+// configuration
+func NewPluginContext(ctx context.Context, manifest *PluginManifest, dir string) (*PluginContext, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.NewPluginContext(ctx, manifest, dir)
+}
+
+// NewPluginContext returns a context for the plugin installed in dir, creating its data
+// and cache directories and loading its configuration with LoadPluginConfig. Its logger is
+// slog's default logger. Hosts running plugins outside of a Manager, and plugins running
+// standalone, use it to call InitPlugin and RunPlugin.
+func (c *Config) NewPluginContext(ctx context.Context, manifest *PluginManifest, dir string) (*PluginContext, error) {
+	name := manifest.Metadata.Name
+	pc := &PluginContext{
+		Context:  ctx,
+		Manifest: manifest,
+		Dir:      dir,
+		DataDir:  c.PluginDataDir(name),
+		CacheDir: c.PluginCacheDir(name),
+		Logger:   slog.Default().With("plugin", name),
+	}
+	for _, dir := range []string{pc.DataDir, pc.CacheDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := c.LoadPluginConfig(manifest)
+	if err != nil {
+		return nil, err
+	}
+	pc.Config = config
+
+	versionInfo, err := c.GetVersionInfo()
+	if err != nil {
+		versionInfo = &VersionInfo{PluginAPIVersion: PluginAPIVersion}
+	}
+	pc.VersionInfo = versionInfo
+
+	return pc, nil
+}
+
+// loggerLevel returns the lowest level logger logs at
+func loggerLevel(logger *slog.Logger) slog.Level {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn} {
+		if logger.Enabled(context.Background(), level) {
+			return level
+		}
+	}
+	return slog.LevelError
+}
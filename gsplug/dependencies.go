@@ -1,88 +1,115 @@
 package gsplug
 
 import (
-	"bufio"
+	"bytes"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
+	"sort"
+
+	"golang.org/x/mod/modfile"
 )
 
-const GitspaceRepoURL = "https://raw.githubusercontent.com/ssotops/gitspace/main/go.mod"
+const (
+	GitspaceRepoURL = "https://raw.githubusercontent.com/ssotops/gitspace/main/go.mod"
+	GitspaceSumURL  = "https://raw.githubusercontent.com/ssotops/gitspace/main/go.sum"
+)
 
-// EnsureGitspaceModFile checks if the gitspace-go.mod file exists, and if not, downloads it
+// EnsureGitspaceModFile ensures gitspace-go.mod exists using the default configuration
 func EnsureGitspaceModFile() error {
-	gitspaceModPath := filepath.Join(os.Getenv("HOME"), ".ssot", "gitspace", "plugins", "gitspace-go.mod")
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.EnsureGitspaceModFile()
+}
+
+// EnsureGitspaceModFile checks if the gitspace-go.mod file exists, and if not, fetches it
+// from the Gitspace repository or, when offline or unreachable, from the download cache
+func (c *Config) EnsureGitspaceModFile() error {
+	gitspaceModPath := c.GitspaceModPath()
 
 	if _, err := os.Stat(gitspaceModPath); os.IsNotExist(err) {
-		// Download the file
-		if err := downloadGitspaceMod(gitspaceModPath); err != nil {
+		data, err := c.fetchCached(CacheGitspaceMod)
+		if err != nil {
 			return fmt.Errorf("failed to download gitspace-go.mod: %w\nPlease manually add the file from %s", err, GitspaceRepoURL)
 		}
+
+		if err := os.MkdirAll(filepath.Dir(gitspaceModPath), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(gitspaceModPath, data, 0644)
 	}
 
 	return nil
 }
 
-// downloadGitspaceMod downloads the go.mod file from the Gitspace repository
-func downloadGitspaceMod(destPath string) error {
-	resp, err := http.Get(GitspaceRepoURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// ensureGitspaceSumFile checks if the gitspace-go.sum file exists, and if not, fetches it
+// the same way as gitspace-go.mod
+func (c *Config) ensureGitspaceSumFile() error {
+	gitspaceSumPath := c.GitspaceSumPath()
 
-	out, err := os.Create(destPath)
-	if err != nil {
-		return err
+	if _, err := os.Stat(gitspaceSumPath); os.IsNotExist(err) {
+		data, err := c.fetchCached(CacheGitspaceSum)
+		if err != nil {
+			return fmt.Errorf("failed to download gitspace-go.sum: %w\nPlease manually add the file from %s", err, GitspaceSumURL)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(gitspaceSumPath), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(gitspaceSumPath, data, 0644)
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+	return nil
 }
 
-// GetGitspaceDependencies parses the gitspace-go.mod file and returns a map of dependencies
+// GetGitspaceDependencies returns Gitspace's dependencies using the default configuration
 func GetGitspaceDependencies() (map[string]string, error) {
-	gitspaceModPath := filepath.Join(os.Getenv("HOME"), ".ssot", "gitspace", "plugins", "gitspace-go.mod")
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.GetGitspaceDependencies()
+}
 
-	if err := EnsureGitspaceModFile(); err != nil {
+// GetGitspaceDependencies parses the gitspace-go.mod file and returns a map of dependencies
+func (c *Config) GetGitspaceDependencies() (map[string]string, error) {
+	if err := c.EnsureGitspaceModFile(); err != nil {
 		return nil, err
 	}
 
-	return parseDependencies(gitspaceModPath)
+	return parseDependencies(c.GitspaceModPath())
 }
 
-// parseDependencies reads a go.mod file and returns a map of dependencies
-func parseDependencies(path string) (map[string]string, error) {
-	file, err := os.Open(path)
+// readModFile reads and parses a go.mod file, returning the parsed file and its raw content
+func readModFile(path string) (*modfile.File, []byte, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer file.Close()
-
-	deps := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	re := regexp.MustCompile(`^(\S+)\s+(\S+)$`)
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "module") || strings.HasPrefix(line, "go ") {
-			continue
-		}
-		matches := re.FindStringSubmatch(line)
-		if len(matches) == 3 {
-			deps[matches[1]] = matches[2]
-		}
+	f, err := modfile.Parse(path, data, nil)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if err := scanner.Err(); err != nil {
+	return f, data, nil
+}
+
+// parseDependencies reads a go.mod file and returns the required version of every module,
+// including indirect requirements
+func parseDependencies(path string) (map[string]string, error) {
+	f, _, err := readModFile(path)
+	if err != nil {
 		return nil, err
 	}
 
+	deps := make(map[string]string, len(f.Require))
+	for _, r := range f.Require {
+		deps[r.Mod.Path] = r.Mod.Version
+	}
+
 	return deps, nil
 }
 
@@ -107,109 +134,194 @@ func MergeDependencies(pluginDeps, gitspaceDeps map[string]string) map[string]st
 	return mergedDeps
 }
 
-// UpdatePluginDependencies updates the plugin's go.mod file with merged dependencies
+// Reasons reported in a DependencyChange
+const (
+	// ReasonGitspace means the version was pinned to the one in Gitspace's go.mod
+	ReasonGitspace = "gitspace"
+	// ReasonCanonical means the version was pinned by canonical-deps.json
+	ReasonCanonical = "canonical"
+	// ReasonPlugin means the plugin's own version was kept
+	ReasonPlugin = "plugin"
+)
+
+// DependencyChange describes the resolved version of one requirement of a plugin
+type DependencyChange struct {
+	Module     string `json:"module"`
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+	Reason     string `json:"reason"`
+}
+
+// DependencyPlan describes how updating a plugin's dependencies would change its go.mod
+type DependencyPlan struct {
+	Path     string
+	Original []byte
+	Updated  []byte
+	// Changes lists every requirement of the plugin, sorted by module path
+	Changes []DependencyChange
+}
+
+// Changed reports whether applying the plan would modify go.mod
+func (p *DependencyPlan) Changed() bool {
+	return !bytes.Equal(p.Original, p.Updated)
+}
+
+// Diff returns a unified diff of the go.mod changes, or "" if there are none
+func (p *DependencyPlan) Diff() string {
+	return unifiedDiff(p.Path, p.Path, p.Original, p.Updated)
+}
+
+// Apply writes the updated go.mod to disk if it changed
+func (p *DependencyPlan) Apply() error {
+	if !p.Changed() {
+		return nil
+	}
+	return os.WriteFile(p.Path, p.Updated, 0644)
+}
+
+// UpdatePluginDependencies updates the plugin's dependencies using the default configuration
 func UpdatePluginDependencies(pluginDir string) error {
-	pluginModPath := filepath.Join(pluginDir, "go.mod")
-	pluginDeps, err := parseDependencies(pluginModPath)
+	cfg, err := DefaultConfig()
 	if err != nil {
-		return fmt.Errorf("failed to parse plugin dependencies: %w", err)
+		return err
 	}
+	return cfg.UpdatePluginDependencies(pluginDir)
+}
 
-	gitspaceDeps, err := GetGitspaceDependencies()
+// UpdatePluginDependencies pins the plugin's requirements to the versions Gitspace uses and
+// sets its go directive to Gitspace's
+func (c *Config) UpdatePluginDependencies(pluginDir string) error {
+	plan, err := c.PlanDependencyUpdate(pluginDir, nil)
 	if err != nil {
-		return fmt.Errorf("failed to get Gitspace dependencies: %w", err)
+		return err
 	}
 
-	mergedDeps := MergeDependencies(pluginDeps, gitspaceDeps)
+	return plan.Apply()
+}
 
-	// Write updated go.mod
-	return writeGoMod(pluginModPath, mergedDeps)
+// PlanDependencyUpdate plans a dependency update using the default configuration
+func PlanDependencyUpdate(pluginDir string, canonical *CanonicalDeps) (*DependencyPlan, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.PlanDependencyUpdate(pluginDir, canonical)
 }
 
-// writeGoMod writes the merged dependencies to the plugin's go.mod file
-func writeGoMod(path string, deps map[string]string) error {
-	file, err := os.Create(path)
+// PlanDependencyUpdate computes the changes UpdatePluginDependencies would make to the
+// plugin's go.mod without writing anything. Versions from canonical, if given, take
+// precedence over Gitspace's. Modules the plugin does not require are not added, and
+// everything else in go.mod (replace, exclude, retract, toolchain, comments, indirect
+// markers) is kept as is.
+func (c *Config) PlanDependencyUpdate(pluginDir string, canonical *CanonicalDeps) (*DependencyPlan, error) {
+	pluginModPath := filepath.Join(pluginDir, "go.mod")
+	f, data, err := readModFile(pluginModPath)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to parse plugin dependencies: %w", err)
 	}
-	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	pluginDeps := make(map[string]string, len(f.Require))
+	for _, r := range f.Require {
+		pluginDeps[r.Mod.Path] = r.Mod.Version
+	}
 
-	// Write module declaration (preserving the original module name)
-	originalModule, err := getModuleName(path)
+	gitspaceDeps, err := c.GetGitspaceDependencies()
 	if err != nil {
-		return fmt.Errorf("failed to get original module name: %w", err)
+		return nil, fmt.Errorf("failed to get Gitspace dependencies: %w", err)
 	}
-	_, err = fmt.Fprintf(writer, "module %s\n\n", originalModule)
+
+	// Use the same Go version as Gitspace
+	gitspaceGoVersion, err := getGoVersion(c.GitspaceModPath())
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to get Gitspace Go version: %w", err)
 	}
 
-	// Write go version (using the same version as Gitspace)
-	gitspaceGoVersion, err := getGoVersion(filepath.Join(os.Getenv("HOME"), ".ssot", "gitspace", "plugins", "gitspace-go.mod"))
-	if err != nil {
-		return fmt.Errorf("failed to get Gitspace Go version: %w", err)
+	mergedDeps := MergeDependencies(pluginDeps, gitspaceDeps)
+	reasons := make(map[string]string, len(pluginDeps))
+	for module := range pluginDeps {
+		reasons[module] = ReasonPlugin
+		if _, ok := gitspaceDeps[module]; ok {
+			reasons[module] = ReasonGitspace
+		}
 	}
-	_, err = fmt.Fprintf(writer, "go %s\n\n", gitspaceGoVersion)
-	if err != nil {
-		return err
+	if canonical != nil {
+		for module, version := range canonical.Versions {
+			if _, ok := pluginDeps[module]; ok {
+				mergedDeps[module] = version
+				reasons[module] = ReasonCanonical
+			}
+		}
 	}
 
-	// Write dependencies
-	_, err = fmt.Fprintln(writer, "require (")
+	pinned, err := pinRequirements(f, mergedDeps)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for dep, version := range deps {
-		_, err = fmt.Fprintf(writer, "\t%s %s\n", dep, version)
-		if err != nil {
-			return err
+	modified := pinned > 0
+	if f.Go == nil || f.Go.Version != gitspaceGoVersion {
+		if err := f.AddGoStmt(gitspaceGoVersion); err != nil {
+			return nil, err
 		}
+		modified = true
 	}
-	_, err = fmt.Fprintln(writer, ")")
-	if err != nil {
-		return err
+
+	plan := &DependencyPlan{
+		Path:     pluginModPath,
+		Original: data,
+		// Leave files that need no update byte-for-byte unchanged
+		Updated: data,
+	}
+	if modified {
+		f.Cleanup()
+		if plan.Updated, err = f.Format(); err != nil {
+			return nil, err
+		}
+	}
+	for module, version := range pluginDeps {
+		plan.Changes = append(plan.Changes, DependencyChange{
+			Module:     module,
+			OldVersion: version,
+			NewVersion: mergedDeps[module],
+			Reason:     reasons[module],
+		})
 	}
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].Module < plan.Changes[j].Module
+	})
 
-	return nil
+	return plan, nil
 }
 
-// getModuleName retrieves the module name from a go.mod file
-func getModuleName(path string) (string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return "", err
+// pinRequirements updates existing require lines in place to the versions given,
+// keeping their position and comments. It returns the number of modules updated.
+func pinRequirements(f *modfile.File, versions map[string]string) (int, error) {
+	// Collect first: AddRequire may drop duplicate lines from f.Require
+	var updates []*modfile.Require
+	for _, r := range f.Require {
+		if version, ok := versions[r.Mod.Path]; ok && version != r.Mod.Version {
+			updates = append(updates, r)
+		}
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "module ") {
-			return strings.TrimPrefix(line, "module "), nil
+	for _, r := range updates {
+		if err := f.AddRequire(r.Mod.Path, versions[r.Mod.Path]); err != nil {
+			return 0, fmt.Errorf("failed to update %s: %w", r.Mod.Path, err)
 		}
 	}
 
-	return "", fmt.Errorf("module declaration not found in go.mod")
+	return len(updates), nil
 }
 
 // getGoVersion retrieves the Go version from a go.mod file
 func getGoVersion(path string) (string, error) {
-	file, err := os.Open(path)
+	f, _, err := readModFile(path)
 	if err != nil {
 		return "", err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "go ") {
-			return strings.TrimPrefix(line, "go "), nil
-		}
+	if f.Go == nil {
+		return "", fmt.Errorf("go version not found in %s", path)
 	}
 
-	return "", fmt.Errorf("go version not found in go.mod")
+	return f.Go.Version, nil
 }
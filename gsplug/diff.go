@@ -0,0 +1,144 @@
+package gsplug
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// noNewlineMarker follows a line that does not end with a newline, as in diff(1)
+const noNewlineMarker = "\\ No newline at end of file"
+
+// diffLine is a line of a diff's input without its trailing newline
+type diffLine struct {
+	text string
+	// noNewline is set on the last line of an input that does not end with a newline, so
+	// that it differs from the same text followed by one
+	noNewline bool
+}
+
+// diffOp is a single line of a line-based diff
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	diffLine
+	// aLine and bLine are the 1-based line numbers this op sits at in each input
+	aLine, bLine int
+}
+
+// unifiedDiff returns a unified diff between from and to, or "" if they are equal
+func unifiedDiff(fromName, toName string, from, to []byte) string {
+	ops := diffLines(splitLines(string(from)), splitLines(string(to)))
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		start := max(changes[i]-diffContext, 0)
+		end := changes[i]
+		// Extend the hunk while the next change is close enough to share context
+		for i < len(changes) && changes[i]-end <= 2*diffContext {
+			end = changes[i]
+			i++
+		}
+		end = min(end+diffContext, len(ops)-1)
+
+		writeHunk(&out, ops[start:end+1])
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, ops []diffOp) {
+	aStart, bStart := ops[0].aLine, ops[0].bLine
+	aCount, bCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+	// An empty range starts at the line before it
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, op := range ops {
+		out.WriteByte(op.kind)
+		out.WriteString(op.text)
+		out.WriteByte('\n')
+		if op.noNewline {
+			out.WriteString(noNewlineMarker + "\n")
+		}
+	}
+}
+
+// diffLines computes a minimal line diff of a and b using their longest common subsequence
+func diffLines(a, b []diffLine) []diffOp {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', diffLine: a[i], aLine: i + 1, bLine: j + 1})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', diffLine: a[i], aLine: i + 1, bLine: j + 1})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', diffLine: b[j], aLine: i + 1, bLine: j + 1})
+			j++
+		}
+	}
+
+	return ops
+}
+
+// splitLines splits s into lines without their trailing newlines, marking a last line
+// that has none
+func splitLines(s string) []diffLine {
+	if s == "" {
+		return nil
+	}
+	trimmed, hasNewline := strings.CutSuffix(s, "\n")
+	texts := strings.Split(trimmed, "\n")
+	lines := make([]diffLine, len(texts))
+	for i, text := range texts {
+		lines[i] = diffLine{text: text}
+	}
+	lines[len(lines)-1].noNewline = !hasNewline
+	return lines
+}
@@ -0,0 +1,121 @@
+package gsplug
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines "1" to "n", each followed by a newline, with the lines
+// in replace substituted
+func numberedLines(n int, replace map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		line, ok := replace[i]
+		if !ok {
+			line = fmt.Sprint(i)
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{
+			name: "equal",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "added final newline",
+			from: "a\nb",
+			to:   "a\nb\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "removed final newline",
+			from: "a\nb\n",
+			to:   "a\nb",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "equal without a final newline",
+			from: "a\nb",
+			to:   "a\nb",
+			want: "",
+		},
+		{
+			name: "context line without a final newline",
+			from: "a\nb",
+			to:   "A\nb",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,2 @@\n-a\n+A\n b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "changed line with context",
+			from: numberedLines(10, nil),
+			to:   numberedLines(10, map[int]string{5: "five"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "context is clipped at the ends",
+			from: "1\n2\n3\n",
+			to:   "one\n2\n3\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n-1\n+one\n 2\n 3\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			from: numberedLines(12, nil),
+			to:   numberedLines(12, map[int]string{3: "three", 9: "nine"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n",
+		},
+		{
+			name: "distant changes get separate hunks",
+			from: numberedLines(20, nil),
+			to:   numberedLines(20, map[int]string{2: "two", 18: "eighteen"}),
+			want: "--- a\n+++ b\n" +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			name: "inserted lines",
+			from: "a\nc\n",
+			to:   "a\nb1\nb2\nc\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,4 @@\n a\n+b1\n+b2\n c\n",
+		},
+		{
+			name: "new file",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- a\n+++ b\n" +
+				"@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "emptied file",
+			from: "a\n",
+			to:   "",
+			want: "--- a\n+++ b\n" +
+				"@@ -1,1 +0,0 @@\n-a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("a", "b", []byte(tt.from), []byte(tt.to)); got != tt.want {
+				t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,641 @@
+package gsplug
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+)
+
+// scpLikeURL matches Git's user@host:path syntax for remote repositories
+var scpLikeURL = regexp.MustCompile(`^[^/]+@[^/]+:`)
+
+// InstallOptions controls how Install installs a plugin
+type InstallOptions struct {
+	// Ref is the branch, tag or commit to check out when installing from Git. Setting it
+	// installs a local directory from its Git history rather than its working tree.
+	Ref string
+	// Force replaces an installed plugin of the same name, and installs packages built
+	// for another platform, Go toolchain or Gitspace version
+	Force bool
+	// Build controls the build of plugins installed from source. Of its fields, only
+	// Runner, Toolchain and Reproducible apply.
+	Build BuildOptions
+}
+
+// PluginStatus describes a plugin in the plugins directory, as returned by ListPlugins
+type PluginStatus struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Dir     string `json:"dir"`
+	// Installed is the registry entry, or nil for plugins put in the plugins directory
+	// by other means
+	Installed *InstalledPlugin `json:"installed,omitempty"`
+	// Compatible reports whether the current Gitspace can use the plugin; Problem says why not
+	Compatible bool   `json:"compatible"`
+	Problem    string `json:"problem,omitempty"`
+	// BuiltAt is when the artifacts were built, or zero if they never were
+	BuiltAt   time.Time        `json:"built_at"`
+	Artifacts []ArtifactStatus `json:"artifacts"`
+}
+
+// ArtifactStatus is an artifact of an installed plugin
+type ArtifactStatus struct {
+	Mode    string `json:"mode"`
+	Path    string `json:"path"`
+	Present bool   `json:"present"`
+}
+
+// InstallPlugin installs a plugin using the default configuration
+func InstallPlugin(source string) (*InstalledPlugin, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Install(context.Background(), source, InstallOptions{})
+}
+
+// UninstallPlugin removes an installed plugin using the default configuration
+func UninstallPlugin(name string) error {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return cfg.Uninstall(name)
+}
+
+// ListPlugins lists the plugins in the default configuration's plugins directory
+func ListPlugins() ([]PluginStatus, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.ListPlugins()
+}
+
+// Install installs a plugin into the plugins directory, in a directory named after the
+// plugin, and records it in the registry. The source is a package written by Package, a
+// plugin source directory, or a local Git repository (a path or file:// URL). Plugins
+// installed from source are built, and streamed build output goes to os.Stdout. The
+// signatures of a package's artifacts are verified against the trusted keys; unsigned
+// artifacts are refused if RequireSignatures is set.
+//
+// The plugin is prepared in a temporary directory and moved into place only once it is
+// complete, so a failed install leaves any previously installed version untouched.
+func (c *Config) Install(ctx context.Context, source string, opts InstallOptions) (*InstalledPlugin, error) {
+	kind, err := installSourceKind(source, opts.Ref)
+	if err != nil {
+		return nil, err
+	}
+	if kind != InstallFromGit || !strings.HasPrefix(source, "file://") {
+		if source, err = filepath.Abs(source); err != nil {
+			return nil, err
+		}
+	}
+
+	pluginsDir := c.PluginsPath()
+	if err := os.MkdirAll(pluginsDir, 0755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(pluginsDir, ".gsplug-install-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	// The plugin is moved to staging/<name> once its name is known, since default
+	// artifact names come from the plugin directory's name
+	dir := filepath.Join(staging, "plugin")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entry := &InstalledPlugin{Kind: kind, Source: source, InstalledAt: time.Now().UTC()}
+	var pkg *Package
+	switch kind {
+	case InstallFromPackage:
+		if pkg, err = ReadPackage(source); err != nil {
+			return nil, err
+		}
+		if err := pkg.Extract(dir); err != nil {
+			return nil, fmt.Errorf("failed to extract package: %w", err)
+		}
+		signers, err := c.verifyArtifacts(dir, pkg.Info.Artifacts, c.RequireSignatures)
+		if err != nil {
+			return nil, fmt.Errorf("signature verification failed: %w", err)
+		}
+		for _, key := range signers {
+			if !slices.Contains(entry.SignedBy, key.ID()) {
+				entry.SignedBy = append(entry.SignedBy, key.ID())
+			}
+		}
+		sort.Strings(entry.SignedBy)
+		if entry.SHA256, err = hashFile(source); err != nil {
+			return nil, err
+		}
+		entry.Artifacts = pkg.Info.Artifacts
+		entry.Platform = pkg.Info.Platform
+		entry.Toolchain = pkg.Info.Toolchain
+		entry.BuiltAt = pkg.Info.BuiltAt
+
+	case InstallFromDir:
+		if err := copyPluginSource(source, dir); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", source, err)
+		}
+
+	case InstallFromGit:
+		if entry.Commit, err = cloneGitSource(ctx, source, opts.Ref, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := ReadManifest(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a plugin: %w", source, err)
+	}
+	name := manifest.Metadata.Name
+	if !pluginNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("plugin has invalid name %q", name)
+	}
+	entry.Name = name
+	entry.Version = manifest.Metadata.Version
+	entry.Dir = name
+	if err := os.Rename(dir, filepath.Join(staging, name)); err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(staging, name)
+
+	target := filepath.Join(pluginsDir, name)
+	_, statErr := os.Stat(target)
+	if statErr == nil && !opts.Force {
+		return nil, fmt.Errorf("plugin %s is already installed in %s", name, target)
+	}
+
+	if pkg != nil {
+		if !opts.Force {
+			if err := c.checkBuilt(manifest, pkg.Info.Platform, pkg.Info.Toolchain, hasGoPlugin(pkg.Info.Artifacts)); err != nil {
+				return nil, fmt.Errorf("cannot install %s %s: %w", name, entry.Version, err)
+			}
+		}
+	} else {
+		result := c.Build(ctx, dir, BuildOptions{
+			Runner:       opts.Build.Runner,
+			Toolchain:    opts.Build.Toolchain,
+			Reproducible: opts.Build.Reproducible,
+		})
+		if result.Err != nil {
+			return nil, result.Err
+		}
+	}
+
+	// Swap the new plugin in, keeping the old one until the swap has succeeded
+	if statErr == nil {
+		old := staging + ".old"
+		if err := os.Rename(target, old); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(old)
+		if err := os.Rename(dir, target); err != nil {
+			if restoreErr := os.Rename(old, target); restoreErr != nil {
+				return nil, fmt.Errorf("failed to install %s: %v; the previous version is in %s", name, err, old)
+			}
+			return nil, err
+		}
+	} else if err := os.Rename(dir, target); err != nil {
+		return nil, err
+	}
+
+	err = c.updateRegistry(func(registry *Registry) error {
+		registry.Plugins[name] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("installed %s but failed to update the registry: %w", name, err)
+	}
+
+	return entry, nil
+}
+
+// Uninstall removes an installed plugin and its registry entry. Plugins put in the plugins
+// directory by other means can be removed too.
+func (c *Config) Uninstall(name string) error {
+	if !pluginNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	registry, err := c.ReadRegistry()
+	if err != nil {
+		return err
+	}
+
+	pluginsDir := c.PluginsPath()
+	dir := filepath.Join(pluginsDir, name)
+	entry, registered := registry.Plugins[name]
+	if registered {
+		dir = filepath.Join(pluginsDir, entry.Dir)
+	}
+
+	_, statErr := os.Stat(filepath.Join(dir, ManifestFileName))
+	if !registered && statErr != nil {
+		return fmt.Errorf("plugin %s is not installed", name)
+	}
+
+	if _, err := os.Stat(dir); err == nil {
+		// Move the plugin out of the way first, so it disappears at once even if
+		// deleting its files is slow or fails part way
+		trash, err := os.MkdirTemp(pluginsDir, ".gsplug-uninstall-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(trash)
+		if err := os.Rename(dir, filepath.Join(trash, name)); err != nil {
+			return err
+		}
+	}
+
+	if !registered {
+		return nil
+	}
+	return c.updateRegistry(func(registry *Registry) error {
+		delete(registry.Plugins, name)
+		return nil
+	})
+}
+
+// ListPlugins describes every plugin in the plugins directory, whether it was installed by
+// Install or not, and every registered plugin whose directory has gone. The result is
+// sorted by name.
+func (c *Config) ListPlugins() ([]PluginStatus, error) {
+	dirs, err := c.discoverPlugins()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]PluginStatus, 0, len(dirs))
+	for dir, installed := range dirs {
+		statuses = append(statuses, c.pluginStatus(dir, installed))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses, nil
+}
+
+// FindPlugin returns the directory and manifest of the plugin in the plugins directory
+// whose manifest has the given name
+func (c *Config) FindPlugin(name string) (string, *PluginManifest, error) {
+	dirs, err := c.discoverPlugins()
+	if err != nil {
+		return "", nil, err
+	}
+
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+
+	for _, dir := range sorted {
+		manifest, err := ReadManifest(filepath.Join(dir, ManifestFileName))
+		if err == nil && manifest.Metadata.Name == name {
+			return dir, manifest, nil
+		}
+	}
+	return "", nil, fmt.Errorf("plugin %s is not installed", name)
+}
+
+// discoverPlugins returns the registry entry, or nil, of every plugin directory: those
+// in the registry and those in the plugins directory that hold a manifest
+func (c *Config) discoverPlugins() (map[string]*InstalledPlugin, error) {
+	registry, err := c.ReadRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	pluginsDir := c.PluginsPath()
+	dirs := make(map[string]*InstalledPlugin)
+	for _, entry := range registry.Plugins {
+		dirs[filepath.Join(pluginsDir, entry.Dir)] = entry
+	}
+
+	entries, err := os.ReadDir(pluginsDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read plugins directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		dir := filepath.Join(pluginsDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, ManifestFileName)); err == nil {
+			if _, ok := dirs[dir]; !ok {
+				dirs[dir] = nil
+			}
+		}
+	}
+
+	return dirs, nil
+}
+
+// pluginStatus describes the plugin in dir, whose registry entry may be nil
+func (c *Config) pluginStatus(dir string, installed *InstalledPlugin) PluginStatus {
+	status := PluginStatus{Name: filepath.Base(dir), Dir: dir, Installed: installed}
+	if installed != nil {
+		status.Name = installed.Name
+		status.Version = installed.Version
+	}
+
+	manifest, err := ReadManifest(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		status.Problem = fmt.Sprintf("failed to read manifest: %v", err)
+		return status
+	}
+	status.Name = manifest.Metadata.Name
+	status.Version = manifest.Metadata.Version
+
+	built, err := installedArtifacts(dir, manifest, installed)
+	if err != nil {
+		status.Problem = err.Error()
+		return status
+	}
+	status.BuiltAt = built.BuiltAt
+	status.Artifacts = built.Artifacts
+
+	if err := c.checkBuilt(manifest, built.Platform, built.Toolchain, built.GoPlugin); err != nil {
+		status.Problem = err.Error()
+	} else {
+		status.Compatible = true
+	}
+
+	return status
+}
+
+// builtArtifacts are the artifacts of a plugin in the plugins directory and what they
+// were built for
+type builtArtifacts struct {
+	Artifacts []ArtifactStatus
+	// GoPlugin is set if any of the artifacts is a Go plugin
+	GoPlugin bool
+	// Platform, Toolchain and BuiltAt are empty if the plugin was never built
+	Platform  string
+	Toolchain string
+	BuiltAt   time.Time
+}
+
+// installedArtifacts returns the artifacts of the plugin in dir, whose registry entry may
+// be nil. Packages record their prebuilt artifacts in the registry; plugins installed from
+// source have the artifacts their manifest describes, as recorded by their last build.
+func installedArtifacts(dir string, manifest *PluginManifest, installed *InstalledPlugin) (*builtArtifacts, error) {
+	built := &builtArtifacts{}
+
+	var artifacts []PackageArtifact
+	if installed != nil && installed.Kind == InstallFromPackage {
+		artifacts = installed.Artifacts
+		built.Platform, built.Toolchain = installed.Platform, installed.Toolchain
+		built.BuiltAt = installed.BuiltAt
+	} else {
+		paths, err := manifest.Artifacts(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, artifact := range paths {
+			rel, err := filepath.Rel(dir, artifact.Path)
+			if err != nil {
+				rel = artifact.Path
+			}
+			artifacts = append(artifacts, PackageArtifact{Mode: artifact.Mode, Path: rel})
+		}
+		if record, err := readBuildRecord(dir, paths); err == nil {
+			built.Platform, built.Toolchain = record.Platform, record.Toolchain
+			built.BuiltAt = record.BuiltAt
+		}
+	}
+
+	built.GoPlugin = hasGoPlugin(artifacts)
+	for _, artifact := range artifacts {
+		path := artifact.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		_, err := os.Stat(path)
+		built.Artifacts = append(built.Artifacts, ArtifactStatus{Mode: artifact.Mode, Path: path, Present: err == nil})
+	}
+
+	return built, nil
+}
+
+// checkBuilt checks that the current Gitspace can use a plugin whose artifacts were built
+// for platform with the Go toolchain, either of which may be unknown
+func (c *Config) checkBuilt(manifest *PluginManifest, platform, toolchain string, goPlugin bool) error {
+	if err := c.CheckManifestCompatibility(manifest); err != nil {
+		return err
+	}
+
+	if host := runtime.GOOS + "/" + runtime.GOARCH; platform != "" && platform != host {
+		return fmt.Errorf("built for %s, but this machine is %s", platform, host)
+	}
+
+	// Only Go plugins must match the host's toolchain; binaries talk to it over RPC
+	if goPlugin && toolchain != "" {
+		required, err := c.pluginGoVersion()
+		if err != nil {
+			return err
+		}
+		if strings.TrimPrefix(toolchain, "go") != required {
+			return fmt.Errorf("Go plugin built with %s will not load into Gitspace, which is built with go%s", toolchain, required)
+		}
+	}
+
+	return nil
+}
+
+// hasGoPlugin reports whether any of the artifacts is a Go plugin
+func hasGoPlugin(artifacts []PackageArtifact) bool {
+	for _, artifact := range artifacts {
+		if artifact.Mode == BuildModePlugin {
+			return true
+		}
+	}
+	return false
+}
+
+// installSourceKind returns how to install from source: InstallFromPackage,
+// InstallFromDir or InstallFromGit
+func installSourceKind(source, ref string) (string, error) {
+	if strings.HasPrefix(source, "file://") {
+		return InstallFromGit, nil
+	}
+	if strings.Contains(source, "://") || scpLikeURL.MatchString(source) {
+		return "", fmt.Errorf("cannot install from %s: only local packages, directories and Git repositories are supported", source)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !info.IsDir():
+		return InstallFromPackage, nil
+	case ref != "" || isBareGitRepo(source):
+		return InstallFromGit, nil
+	default:
+		return InstallFromDir, nil
+	}
+}
+
+// isBareGitRepo reports whether dir is a Git repository without a working tree
+func isBareGitRepo(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	_, err := os.Stat(filepath.Join(dir, ManifestFileName))
+	return err != nil
+}
+
+// copyPluginSource copies the plugin source in src to dst, leaving out the Git directory
+// and the build outputs
+func copyPluginSource(src, dst string) error {
+	skip := map[string]bool{filepath.Join(src, ".git"): true}
+	if manifest, err := ReadManifest(filepath.Join(src, ManifestFileName)); err == nil {
+		if artifacts, err := manifest.Artifacts(src); err == nil {
+			for _, artifact := range artifacts {
+				skip[artifact.Path] = true
+				if outDir := filepath.Dir(artifact.Path); outDir != src {
+					skip[outDir] = true
+				}
+			}
+		}
+	}
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if skip[path] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(target, data, info.Mode().Perm())
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return absoluteReplacements(filepath.Join(dst, "go.mod"), src)
+}
+
+// cloneGitSource clones the repository at source into dst, which must be empty, checks
+// out ref if set, and returns the commit. The .git directory is removed afterwards.
+func cloneGitSource(ctx context.Context, source, ref, dst string) (string, error) {
+	git := func(args ...string) ([]byte, error) {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+		}
+		return out, nil
+	}
+
+	if _, err := git("clone", "--quiet", "--", source, dst); err != nil {
+		return "", err
+	}
+	if ref != "" {
+		if _, err := git("-C", dst, "checkout", "--quiet", ref); err != nil {
+			return "", err
+		}
+	}
+	out, err := git("-C", dst, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dst, ".git")); err != nil {
+		return "", err
+	}
+
+	// Relative replacements point next to a local working tree, not the clone
+	if info, err := os.Stat(source); err == nil && info.IsDir() && !isBareGitRepo(source) {
+		if err := absoluteReplacements(filepath.Join(dst, "go.mod"), source); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// absoluteReplacements rewrites relative directory replacements in the go.mod at
+// goModPath so that they resolve from baseDir, where the module was copied from
+func absoluteReplacements(goModPath, baseDir string) error {
+	f, _, err := readModFile(goModPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, replace := range f.Replace {
+		if replace.New.Version != "" || filepath.IsAbs(replace.New.Path) || !modfile.IsDirectoryPath(replace.New.Path) {
+			continue
+		}
+		dir := filepath.Join(baseDir, filepath.FromSlash(replace.New.Path))
+		if err := f.AddReplace(replace.Old.Path, replace.Old.Version, dir, ""); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	data, err := f.Format()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(goModPath, data, 0644)
+}
@@ -0,0 +1,117 @@
+package gsplug
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestConfig returns an offline configuration in a temporary home holding the files a
+// build needs: Gitspace's go.mod and the canonical dependencies
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg := &Config{Home: t.TempDir(), Offline: true}
+	writeTestFile(t, cfg.GitspaceModPath(), "module github.com/ssotops/gitspace\n\ngo 1.23.1\n")
+	writeTestFile(t, cfg.CanonicalDepsPath(), `{"versions": {}}`)
+	return cfg
+}
+
+// writeTestPlugin writes a dependency-free plugin named hello to dir, whose manifest ends
+// with build, the body of its [build] table
+func writeTestPlugin(t *testing.T, dir, build string) {
+	t.Helper()
+	writeTestFile(t, filepath.Join(dir, "go.mod"), "module example.com/hello\n\ngo 1.23.1\n")
+	writeTestFile(t, filepath.Join(dir, "main.go"), "package main\n\nfunc main() {}\n")
+	writeTestFile(t, filepath.Join(dir, ManifestFileName), `[metadata]
+name = "hello"
+version = "1.0.0"
+
+[[sources]]
+path = "."
+entry_point = "Plugin"
+
+[build]
+`+build)
+}
+
+// writeTestFile writes content to path, creating its directory
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstallBuildRecord(t *testing.T) {
+	tests := []struct {
+		name  string
+		build string
+		// want are the artifact paths stored in the build record
+		want []string
+	}{
+		{name: "default output", build: "mode = \"binary\"\n", want: []string{"dist/hello"}},
+		{name: "output in the plugin root", build: "binary = \"hello\"\n", want: []string{"hello"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			source := t.TempDir()
+			writeTestPlugin(t, source, tt.build)
+
+			if _, err := cfg.Install(context.Background(), source, InstallOptions{}); err != nil {
+				t.Fatalf("Install: %v", err)
+			}
+
+			// The plugin was built in a staging directory and then moved into place
+			dir := filepath.Join(cfg.PluginsPath(), "hello")
+			manifest, err := ReadManifest(filepath.Join(dir, ManifestFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			artifacts, err := manifest.Artifacts(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(buildRecordPath(artifacts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stored BuildRecord
+			if err := json.Unmarshal(data, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stored.Artifacts, tt.want) {
+				t.Errorf("stored artifacts = %q, want %q", stored.Artifacts, tt.want)
+			}
+
+			record, err := readBuildRecord(dir, artifacts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, path := range record.Artifacts {
+				if path != artifacts[i].Path {
+					t.Errorf("artifact %d = %s, want %s", i, path, artifacts[i].Path)
+				}
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("recorded artifact: %v", err)
+				}
+			}
+
+			statuses, err := cfg.ListPlugins()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(statuses) != 1 || !statuses[0].Compatible || statuses[0].BuiltAt.IsZero() {
+				t.Errorf("ListPlugins() = %+v, want hello built and compatible", statuses)
+			}
+		})
+	}
+}
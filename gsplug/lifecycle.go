@@ -0,0 +1,111 @@
+package gsplug
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// Timeouts applied when the manifest's [runtime] table does not set them. Plugins run
+// without a time limit by default, since many of them are interactive.
+const (
+	DefaultInitTimeout     = 30 * time.Second
+	DefaultRunTimeout      = time.Duration(0)
+	DefaultShutdownTimeout = 10 * time.Second
+)
+
+// Stopper is implemented by plugins that need to finish their work and release resources
+// before Gitspace exits. Stop must return by the context's deadline. Hosts call it instead
+// of Shutdowner.Shutdown.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// StopPlugin stops p with Stop if it is a Stopper, and with Shutdown if it is a Shutdowner
+func StopPlugin(ctx context.Context, p Plugin) error {
+	switch v := p.(type) {
+	case Stopper:
+		return v.Stop(ctx)
+	case Shutdowner:
+		return v.Shutdown()
+	}
+	return nil
+}
+
+// PanicError is returned when a plugin panics during a call from the host
+type PanicError struct {
+	Plugin string
+	// Value is the value passed to panic
+	Value any
+	// Stack is the stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("plugin %s panicked: %v\n\n%s", e.Plugin, e.Value, e.Stack)
+}
+
+// Timeouts are the time limits the host puts on calls to a plugin. Zero means no limit.
+type Timeouts struct {
+	Init     time.Duration
+	Run      time.Duration
+	Shutdown time.Duration
+}
+
+// Timeouts returns the timeouts set by the [runtime] table, with the defaults for those it
+// leaves out or sets to invalid durations
+func (r RuntimeConfig) Timeouts() Timeouts {
+	return Timeouts{
+		Init:     parseTimeout(r.InitTimeout, DefaultInitTimeout),
+		Run:      parseTimeout(r.RunTimeout, DefaultRunTimeout),
+		Shutdown: parseTimeout(r.ShutdownTimeout, DefaultShutdownTimeout),
+	}
+}
+
+// parseTimeout parses a timeout of the [runtime] table
+func parseTimeout(value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return def
+	}
+	return timeout
+}
+
+// guardPanic calls fn, turning a panic into a *PanicError
+func guardPanic(plugin string, fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Plugin: plugin, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// callPlugin calls fn, which calls into the named plugin, with a context that is canceled
+// after timeout, if there is one. It returns once fn does or the context is done, so a
+// plugin that does not honor cancellation cannot block the host. Panics in fn are returned
+// as a *PanicError.
+func callPlugin(ctx context.Context, plugin, call string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout,
+			fmt.Errorf("%w: %s timeout of %s exceeded", context.DeadlineExceeded, call, timeout))
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- guardPanic(plugin, func() error { return fn(ctx) })
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("plugin %s did not return from %s: %w", plugin, call, context.Cause(ctx))
+	}
+}
@@ -0,0 +1,466 @@
+package gsplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Ways a Manager loads plugins, see ManagerOptions.Mode
+const (
+	// LoadAuto opens a plugin's Go plugin in-process if it has one, and otherwise or if
+	// that fails starts its binary out-of-process
+	LoadAuto = "auto"
+	// LoadInProcess only opens Go plugins, built with -buildmode=plugin
+	LoadInProcess = "in-process"
+	// LoadOutOfProcess only starts binaries, which serve the plugin over RPC
+	LoadOutOfProcess = "out-of-process"
+)
+
+// ManagerOptions controls how a Manager loads plugins
+type ManagerOptions struct {
+	// Mode is LoadAuto, LoadInProcess or LoadOutOfProcess. Defaults to LoadAuto.
+	Mode string
+	// Strict refuses plugins whose manifests have unknown keys, see ValidateOptions
+	Strict bool
+	// Logger is the logger handed to plugins in their PluginContext, with the plugin's
+	// name added. Defaults to slog's default logger.
+	Logger *slog.Logger
+	// Services are the host services plugins can look up with PluginContext.Service
+	Services map[string]any
+}
+
+// LoadedPlugin is a plugin loaded and initialized by a Manager
+type LoadedPlugin struct {
+	// Name is the plugin's name in its manifest
+	Name     string
+	Dir      string
+	Manifest *PluginManifest
+	// Installed is the registry entry, or nil for plugins not installed by Install
+	Installed *InstalledPlugin
+	// Mode is the build mode of the artifact at Path: BuildModePlugin for Go plugins
+	// opened in-process, BuildModeBinary for binaries running out-of-process
+	Mode   string
+	Path   string
+	Plugin Plugin
+	// Signer is the trusted key that signed the artifact at Path, or nil if it is not signed
+	Signer *PublicKey
+
+	context *PluginContext
+}
+
+// Context returns the PluginContext the plugin was initialized with, carrying ctx
+func (l *LoadedPlugin) Context(ctx context.Context) *PluginContext {
+	return l.context.WithContext(ctx)
+}
+
+// Run runs the plugin, handing it its PluginContext if it is a ContextRunner. The run is
+// canceled after the manifest's run timeout, and Run returns as soon as ctx is done even
+// if the plugin ignores it. A panic in the plugin is returned as a *PanicError.
+func (l *LoadedPlugin) Run(ctx context.Context) error {
+	return l.call(ctx, "run", func(ctx context.Context) error {
+		return RunPlugin(l.Plugin, l.Context(ctx))
+	})
+}
+
+// call calls fn, which calls into the plugin, with the manifest's timeout for the kind of
+// call: "init", "run" or "shutdown"
+func (l *LoadedPlugin) call(ctx context.Context, call string, fn func(ctx context.Context) error) error {
+	timeouts := l.Manifest.Runtime.Timeouts()
+	timeout := timeouts.Run
+	switch call {
+	case "init":
+		timeout = timeouts.Init
+	case "shutdown":
+		timeout = timeouts.Shutdown
+	}
+	return callPlugin(ctx, l.Name, call, timeout, fn)
+}
+
+// LoadError is why a Manager could not load the plugin in Dir
+type LoadError struct {
+	// Name is the plugin's name, or the name of Dir if its manifest could not be read
+	Name string
+	Dir  string
+	Err  error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("failed to load plugin %s: %v", e.Name, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// Manager discovers the plugins in the plugins directory, loads the compatible ones and
+// initializes them. Plugins are keyed by their manifest name. A plugin that fails to load
+// is recorded as a LoadError and does not keep the others from loading. A Go plugin is
+// initialized at most once over the Manager's lifetime, since it cannot be unloaded.
+//
+// A host typically calls Load once at startup and Close before it exits. A Manager is safe
+// for concurrent use. Loads and Close run one at a time, but Plugin, Plugins, Errors and
+// Menu never wait for plugins to load or shut down.
+type Manager struct {
+	config *Config
+	opts   ManagerOptions
+
+	// loading serializes Load and Close, which call into plugins
+	loading sync.Mutex
+	// mu guards the fields below and is never held while calling into a plugin
+	mu      sync.Mutex
+	plugins map[string]*LoadedPlugin
+	errs    map[string]*LoadError
+	// failed holds the errors of Go plugins opened in-process whose initialization failed,
+	// keyed by directory. They are not retried: opening them again returns the same
+	// instance, which may still be running the failed Init.
+	failed map[string]*LoadError
+	closed bool
+}
+
+// NewManager returns a Manager for the plugins directory of cfg
+func NewManager(cfg *Config, opts ManagerOptions) *Manager {
+	if opts.Mode == "" {
+		opts.Mode = LoadAuto
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Manager{
+		config:  cfg,
+		opts:    opts,
+		plugins: make(map[string]*LoadedPlugin),
+		errs:    make(map[string]*LoadError),
+		failed:  make(map[string]*LoadError),
+	}
+}
+
+// Load discovers the plugins in the plugins directory and loads and initializes those that
+// are not loaded yet. Calling it again picks up newly installed plugins and retries the
+// ones that failed, except Go plugins whose initialization failed, which keep their
+// original error. Plugins that are already loaded are left alone. Load only returns an
+// error if the plugins cannot be discovered or ctx is canceled. Failures of individual
+// plugins are reported by Errors.
+func (m *Manager) Load(ctx context.Context) error {
+	switch m.opts.Mode {
+	case LoadAuto, LoadInProcess, LoadOutOfProcess:
+	default:
+		return fmt.Errorf("unknown load mode %q, want %s, %s or %s", m.opts.Mode, LoadAuto, LoadInProcess, LoadOutOfProcess)
+	}
+
+	discovered, err := m.config.discoverPlugins()
+	if err != nil {
+		return err
+	}
+	dirs := make([]string, 0, len(discovered))
+	for dir := range discovered {
+		dirs = append(dirs, dir)
+	}
+	// Which of two plugins with the same name wins must not depend on map order
+	sort.Strings(dirs)
+
+	m.loading.Lock()
+	defer m.loading.Unlock()
+
+	m.mu.Lock()
+	closed := m.closed
+	if !closed {
+		m.errs = make(map[string]*LoadError)
+	}
+	m.mu.Unlock()
+	if closed {
+		return errors.New("plugin manager is closed")
+	}
+
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		loadErr, failed := m.failed[dir]
+		m.mu.Unlock()
+
+		var loaded *LoadedPlugin
+		if !failed {
+			if loaded, err = m.load(ctx, dir, discovered[dir]); err != nil && !errors.As(err, &loadErr) {
+				loadErr = &LoadError{Name: filepath.Base(dir), Dir: dir, Err: err}
+			}
+		}
+
+		m.mu.Lock()
+		switch {
+		case loadErr != nil:
+			m.errs[dir] = loadErr
+		case loaded != nil:
+			m.plugins[loaded.Name] = loaded
+		}
+		m.mu.Unlock()
+	}
+
+	return nil
+}
+
+// load loads and initializes the plugin in dir. It returns nil, nil if the plugin is
+// already loaded.
+func (m *Manager) load(ctx context.Context, dir string, installed *InstalledPlugin) (*LoadedPlugin, error) {
+	manifestPath := filepath.Join(dir, ManifestFileName)
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	name := manifest.Metadata.Name
+	fail := func(err error) error {
+		return &LoadError{Name: name, Dir: dir, Err: err}
+	}
+
+	if existing, ok := m.Plugin(name); ok {
+		if existing.Dir == dir {
+			return nil, nil
+		}
+		return nil, fail(fmt.Errorf("a plugin of the same name is loaded from %s", existing.Dir))
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fail(err)
+	}
+	validate := ValidateOptions{Dir: dir, Strict: m.opts.Strict}
+	if installed != nil && installed.Kind == InstallFromPackage {
+		// Packages hold the artifacts, not the sources the manifest names
+		validate.Dir = ""
+	}
+	for _, d := range ValidateManifest(data, validate) {
+		if d.Severity == SeverityError {
+			return nil, fail(fmt.Errorf("invalid manifest %s:%s", manifestPath, d))
+		}
+	}
+
+	pc, err := m.config.NewPluginContext(context.Background(), manifest, dir)
+	if err != nil {
+		return nil, fail(err)
+	}
+	if err := pc.Config.Validate(); err != nil {
+		return nil, fail(err)
+	}
+	pc.Logger = m.opts.Logger.With("plugin", name)
+	pc.services = m.opts.Services
+
+	built, err := installedArtifacts(dir, manifest, installed)
+	if err != nil {
+		return nil, fail(err)
+	}
+	candidates, err := m.loadCandidates(built.Artifacts)
+	if err != nil {
+		return nil, fail(err)
+	}
+
+	var p Plugin
+	var artifact ArtifactStatus
+	var signer *PublicKey
+	var errs []error
+	for _, artifact = range candidates {
+		// A tampered or untrusted artifact fails the load instead of falling back to the next
+		signer, err = m.verify(artifact)
+		if err != nil {
+			return nil, fail(err)
+		}
+		// Opening a Go plugin runs its package initializers
+		err = guardPanic(name, func() error {
+			p, err = m.open(manifest, built, artifact)
+			return err
+		})
+		if err == nil {
+			break
+		}
+		p = nil
+		errs = append(errs, err)
+	}
+	if p == nil {
+		return nil, fail(errors.Join(errs...))
+	}
+
+	loaded := &LoadedPlugin{
+		Name:      name,
+		Dir:       dir,
+		Manifest:  manifest,
+		Installed: installed,
+		Mode:      artifact.Mode,
+		Path:      artifact.Path,
+		Plugin:    p,
+		Signer:    signer,
+		context:   pc,
+	}
+	err = loaded.call(ctx, "init", func(ctx context.Context) error {
+		configurable, ok := p.(Configurable)
+		if rpcPlugin, isRPC := p.(*RPCPlugin); isRPC {
+			ok = rpcPlugin.HasCapability(CapabilityConfigure)
+		}
+		if ok && len(manifest.Config) > 0 {
+			if err := configurable.Configure(pc.Config.Values()); err != nil {
+				return fmt.Errorf("configure failed: %w", err)
+			}
+		}
+		return InitPlugin(p, loaded.Context(ctx))
+	})
+	if err != nil {
+		loadErr := &LoadError{Name: name, Dir: dir, Err: fmt.Errorf("init failed: %w", err)}
+		// A Go plugin cannot be unloaded, but a plugin process can be stopped
+		if rpcPlugin, ok := p.(*RPCPlugin); ok {
+			rpcPlugin.Close()
+		} else {
+			m.mu.Lock()
+			m.failed[dir] = loadErr
+			m.mu.Unlock()
+		}
+		return nil, loadErr
+	}
+
+	return loaded, nil
+}
+
+// verify checks the artifact's signature before it is opened and returns its signer. An
+// unsigned artifact is accepted, with a nil signer, unless signatures are required; a
+// signature that does not verify never is.
+func (m *Manager) verify(artifact ArtifactStatus) (*PublicKey, error) {
+	key, err := m.config.VerifyArtifact(artifact.Path)
+	if errors.Is(err, ErrNoSignature) && !m.config.RequireSignatures {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(artifact.Path), err)
+	}
+	return &key, nil
+}
+
+// open checks that the artifact suits this Gitspace and opens it
+func (m *Manager) open(manifest *PluginManifest, built *builtArtifacts, artifact ArtifactStatus) (Plugin, error) {
+	if err := m.config.checkBuilt(manifest, built.Platform, built.Toolchain, artifact.Mode == BuildModePlugin); err != nil {
+		return nil, err
+	}
+	if artifact.Mode == BuildModePlugin {
+		return LoadPluginWithManifest(artifact.Path, manifest)
+	}
+	return StartRPCPlugin(artifact.Path)
+}
+
+// loadCandidates returns the built artifacts the load mode allows, in the order they
+// should be tried
+func (m *Manager) loadCandidates(artifacts []ArtifactStatus) ([]ArtifactStatus, error) {
+	var modes []string
+	switch m.opts.Mode {
+	case LoadInProcess:
+		modes = []string{BuildModePlugin}
+	case LoadOutOfProcess:
+		modes = []string{BuildModeBinary}
+	default:
+		modes = []string{BuildModePlugin, BuildModeBinary}
+	}
+
+	var candidates []ArtifactStatus
+	for _, mode := range modes {
+		for _, artifact := range artifacts {
+			if artifact.Mode == mode && artifact.Present {
+				candidates = append(candidates, artifact)
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates, nil
+	}
+
+	for _, artifact := range artifacts {
+		if slices.Contains(modes, artifact.Mode) {
+			return nil, fmt.Errorf("%s is not built", artifact.Path)
+		}
+	}
+	return nil, fmt.Errorf("plugin has no artifact that can be loaded %s", m.opts.Mode)
+}
+
+// Plugin returns the loaded plugin with the given name
+func (m *Manager) Plugin(name string) (*LoadedPlugin, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	loaded, ok := m.plugins[name]
+	return loaded, ok
+}
+
+// Plugins returns the loaded plugins sorted by name
+func (m *Manager) Plugins() []*LoadedPlugin {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	plugins := make([]*LoadedPlugin, 0, len(m.plugins))
+	for _, loaded := range m.plugins {
+		plugins = append(plugins, loaded)
+	}
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name < plugins[j].Name })
+	return plugins
+}
+
+// Errors returns why plugins failed to load during the last Load, sorted by name
+func (m *Manager) Errors() []*LoadError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]*LoadError, 0, len(m.errs))
+	for _, err := range m.errs {
+		errs = append(errs, err)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Name != errs[j].Name {
+			return errs[i].Name < errs[j].Name
+		}
+		return errs[i].Dir < errs[j].Dir
+	})
+	return errs
+}
+
+// Close shuts every loaded plugin down at once, calling Stop or Shutdown with the
+// manifest's shutdown timeout, and then stops plugin processes. All plugins are shut down
+// even if some fail; the returned error joins their errors. The Manager cannot load
+// plugins after Close, since Go plugins cannot be unloaded and initialized again. Close
+// waits for a Load in progress to finish.
+func (m *Manager) Close() error {
+	m.loading.Lock()
+	defer m.loading.Unlock()
+
+	m.mu.Lock()
+	plugins := m.plugins
+	m.plugins = make(map[string]*LoadedPlugin)
+	m.closed = true
+	m.mu.Unlock()
+
+	errs := make(chan error, len(plugins))
+	var wg sync.WaitGroup
+	for _, loaded := range plugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := loaded.call(context.Background(), "shutdown", func(ctx context.Context) error {
+				return StopPlugin(ctx, loaded.Plugin)
+			})
+			if p, ok := loaded.Plugin.(*RPCPlugin); ok {
+				err = errors.Join(err, p.Close())
+			}
+			if err != nil {
+				errs <- fmt.Errorf("plugin %s: %w", loaded.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var joined []error
+	for err := range errs {
+		joined = append(joined, err)
+	}
+	return errors.Join(joined...)
+}
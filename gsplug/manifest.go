@@ -11,8 +11,13 @@ func ReadManifest(path string) (*PluginManifest, error) {
 		return nil, err
 	}
 
+	return ParseManifest(data)
+}
+
+// ParseManifest decodes a manifest, such as one embedded in the plugin with go:embed
+func ParseManifest(data []byte) (*PluginManifest, error) {
 	var manifest PluginManifest
-	err = toml.Unmarshal(data, &manifest)
+	err := toml.Unmarshal(data, &manifest)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,414 @@
+package gsplug
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kinds of MenuConflict
+const (
+	// MenuConflictKey is reported when two items of the same menu have the same key
+	MenuConflictKey = "key"
+	// MenuConflictHotkey is reported when two items of the same menu have the same hotkey
+	MenuConflictHotkey = "hotkey"
+)
+
+// MenuItem is an entry of the Gitspace menu. Plugins that implement MenuContributor
+// contribute a tree of them; other plugins contribute a single item built from
+// GetMenuOption and the manifest's [menu] table.
+type MenuItem struct {
+	// Key identifies the item among its siblings. Items without a key are left out.
+	Key   string
+	Title string
+	// Icon is shown before the title by hosts that support it, for example an emoji
+	Icon string
+	// Hotkey selects the item in its menu, for example "g", "ctrl+g" or "f5"
+	Hotkey string
+	// Weight orders the items of a menu, lightest first. Items of the same weight are
+	// ordered by title.
+	Weight int
+	// Items is the item's submenu
+	Items []*MenuItem
+	// Enabled and Visible are asked each time the menu is shown. Nil means always.
+	Enabled func() bool
+	Visible func() bool
+	// Action runs when the item is chosen. Items that only open a submenu leave it nil.
+	Action func(ctx context.Context) error
+	// Plugin is the name of the plugin that contributed the item, set by MenuRegistry
+	Plugin string
+}
+
+// IsEnabled reports whether the item can be chosen
+func (i *MenuItem) IsEnabled() bool {
+	return i.Enabled == nil || i.Enabled()
+}
+
+// IsVisible reports whether the item should be shown
+func (i *MenuItem) IsVisible() bool {
+	return i.Visible == nil || i.Visible()
+}
+
+// isGroup reports whether the item only opens a submenu, so that the submenus of several
+// plugins can be merged into it
+func (i *MenuItem) isGroup() bool {
+	return i.Action == nil && len(i.Items) > 0
+}
+
+// MenuContributor is implemented by plugins that contribute more to the menu than the
+// single option of GetMenuOption. Returning nil falls back to GetMenuOption.
+type MenuContributor interface {
+	MenuItems() []*MenuItem
+}
+
+// PluginMenuItems returns the menu items contributed by a loaded plugin: its MenuItems if
+// it is a MenuContributor, otherwise an item that runs the plugin, keyed and titled by
+// GetMenuOption or else by the manifest. Plugins without a menu key contribute nothing.
+func PluginMenuItems(manifest *PluginManifest, p Plugin) []*MenuItem {
+	return pluginMenuItems(manifest, p, func(ctx context.Context) error { return p.Run() })
+}
+
+// pluginMenuItems is PluginMenuItems with the action of the default item
+func pluginMenuItems(manifest *PluginManifest, p Plugin, run func(ctx context.Context) error) []*MenuItem {
+	if contributor, ok := p.(MenuContributor); ok {
+		if items := contributor.MenuItems(); items != nil {
+			return items
+		}
+	}
+
+	item := &MenuItem{
+		Key:    manifest.Menu.Key,
+		Title:  manifest.Menu.Title,
+		Icon:   manifest.Menu.Icon,
+		Hotkey: manifest.Menu.Hotkey,
+		Weight: manifest.Menu.Weight,
+		Action: run,
+	}
+	if option := p.GetMenuOption(); option != nil {
+		if option.Key != "" {
+			item.Key = option.Key
+		}
+		if option.Value != "" {
+			item.Title = option.Value
+		}
+	}
+	if item.Key == "" {
+		return nil
+	}
+	if item.Title == "" {
+		item.Title = manifest.Metadata.Name
+	}
+
+	return []*MenuItem{item}
+}
+
+// Menu is the menu merged by a MenuRegistry
+type Menu struct {
+	Items []*MenuItem
+	// Conflicts lists the collisions between contributions and how they were resolved
+	Conflicts []MenuConflict
+}
+
+// Find returns the item at the given path of keys, or nil if there is none
+func (m *Menu) Find(path ...string) *MenuItem {
+	items := m.Items
+	var found *MenuItem
+	for _, key := range path {
+		found = nil
+		for _, item := range items {
+			if strings.EqualFold(item.Key, key) {
+				found = item
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		items = found.Items
+	}
+	return found
+}
+
+// MenuConflict is a collision between two menu items of the same menu
+type MenuConflict struct {
+	// Kind is MenuConflictKey or MenuConflictHotkey, and Value the colliding key or hotkey
+	Kind  string
+	Value string
+	// Path is the keys of the menu holding both items, empty for the top level
+	Path []string
+	// Winner is the plugin that kept Value, or empty for a built-in Gitspace entry.
+	// Loser is the plugin whose item was changed as described by Resolution.
+	Winner     string
+	Loser      string
+	Resolution string
+}
+
+func (c MenuConflict) String() string {
+	owner := "a built-in Gitspace entry"
+	if c.Winner != "" {
+		owner = "plugin " + c.Winner
+	}
+	where := ""
+	if len(c.Path) > 0 {
+		where = " in menu " + strings.Join(c.Path, "/")
+	}
+	return fmt.Sprintf("plugin %s: %s %q%s is taken by %s; %s", c.Loser, c.Kind, c.Value, where, owner, c.Resolution)
+}
+
+// MenuRegistry merges the menu items contributed by plugins into one menu.
+//
+// Contributions are merged in the order of plugin names, so the result does not depend on
+// the order plugins were registered in. When two items of a menu have the same key and
+// both only open a submenu, their submenus are merged. Otherwise the plugin whose name
+// sorts first keeps the key, and the other item's key is prefixed with its plugin's name.
+// Top-level keys reserved for Gitspace's built-in entries are never given to plugins.
+// When two items of a menu have the same hotkey, the first keeps it and the others lose
+// theirs. Every collision is reported in Menu.Conflicts.
+type MenuRegistry struct {
+	mu            sync.Mutex
+	reserved      []string
+	contributions map[string][]*MenuItem
+}
+
+// NewMenuRegistry returns an empty registry that reserves ReservedMenuKeys
+func NewMenuRegistry() *MenuRegistry {
+	return &MenuRegistry{
+		reserved:      append([]string(nil), ReservedMenuKeys...),
+		contributions: make(map[string][]*MenuItem),
+	}
+}
+
+// Reserve keeps plugins from using the given top-level keys, such as those of the host's
+// own menu entries
+func (r *MenuRegistry) Reserve(keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reserved = append(r.reserved, keys...)
+}
+
+// Register sets the items contributed by the named plugin, replacing any it registered
+// before
+func (r *MenuRegistry) Register(plugin string, items []*MenuItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contributions[plugin] = items
+}
+
+// Unregister removes the items contributed by the named plugin
+func (r *MenuRegistry) Unregister(plugin string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.contributions, plugin)
+}
+
+// Menu merges the registered contributions. The items are copies, so the registry and the
+// plugins' own items are left unchanged.
+func (r *MenuRegistry) Menu() *Menu {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	plugins := make([]string, 0, len(r.contributions))
+	for plugin := range r.contributions {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+
+	m := &menuMerger{reserved: make(map[string]bool)}
+	for _, key := range r.reserved {
+		m.reserved[strings.ToLower(key)] = true
+	}
+
+	var items []*MenuItem
+	for _, plugin := range plugins {
+		for _, item := range r.contributions[plugin] {
+			if item != nil {
+				items = m.insert(items, copyMenuItem(item, plugin), nil)
+			}
+		}
+	}
+	m.resolveHotkeys(items, nil)
+	sortMenuItems(items)
+
+	return &Menu{Items: items, Conflicts: m.conflicts}
+}
+
+// menuMerger holds the state of MenuRegistry.Menu
+type menuMerger struct {
+	reserved  map[string]bool
+	conflicts []MenuConflict
+}
+
+// insert adds item to the menu at path, whose items are siblings, and returns the new items
+func (m *menuMerger) insert(siblings []*MenuItem, item *MenuItem, path []string) []*MenuItem {
+	if item.Key == "" {
+		return siblings
+	}
+	taken := func(key string) *MenuItem {
+		for _, sibling := range siblings {
+			if strings.EqualFold(sibling.Key, key) {
+				return sibling
+			}
+		}
+		return nil
+	}
+
+	if len(path) == 0 && m.reserved[strings.ToLower(item.Key)] {
+		m.rename(item, path, "", taken)
+		return append(siblings, item)
+	}
+
+	existing := taken(item.Key)
+	if existing == nil {
+		return append(siblings, item)
+	}
+	if existing.isGroup() && item.isGroup() {
+		childPath := append(append([]string(nil), path...), existing.Key)
+		for _, child := range item.Items {
+			existing.Items = m.insert(existing.Items, child, childPath)
+		}
+		return siblings
+	}
+
+	m.rename(item, path, existing.Plugin, taken)
+	return append(siblings, item)
+}
+
+// rename prefixes the key of item, which collides with an item of winner, with the name of
+// its plugin, adding a number if that key is taken too
+func (m *menuMerger) rename(item *MenuItem, path []string, winner string, taken func(string) *MenuItem) {
+	key := item.Plugin + "." + item.Key
+	for n := 2; taken(key) != nil || (len(path) == 0 && m.reserved[strings.ToLower(key)]); n++ {
+		key = item.Plugin + "." + item.Key + "-" + strconv.Itoa(n)
+	}
+
+	m.conflicts = append(m.conflicts, MenuConflict{
+		Kind:       MenuConflictKey,
+		Value:      item.Key,
+		Path:       path,
+		Winner:     winner,
+		Loser:      item.Plugin,
+		Resolution: fmt.Sprintf("renamed to %q", key),
+	})
+	item.Key = key
+}
+
+// resolveHotkeys clears every hotkey already used by an earlier item of the same menu
+func (m *menuMerger) resolveHotkeys(items []*MenuItem, path []string) {
+	owners := make(map[string]*MenuItem)
+	for _, item := range items {
+		hotkey := strings.ToLower(item.Hotkey)
+		if hotkey != "" {
+			if owner, ok := owners[hotkey]; ok {
+				m.conflicts = append(m.conflicts, MenuConflict{
+					Kind:       MenuConflictHotkey,
+					Value:      item.Hotkey,
+					Path:       path,
+					Winner:     owner.Plugin,
+					Loser:      item.Plugin,
+					Resolution: "hotkey removed",
+				})
+				item.Hotkey = ""
+			} else {
+				owners[hotkey] = item
+			}
+		}
+		m.resolveHotkeys(item.Items, append(append([]string(nil), path...), item.Key))
+	}
+}
+
+// copyMenuItem returns a deep copy of item, attributed to plugin
+func copyMenuItem(item *MenuItem, plugin string) *MenuItem {
+	copied := *item
+	copied.Plugin = plugin
+	copied.Items = nil
+	for _, child := range item.Items {
+		if child != nil {
+			copied.Items = append(copied.Items, copyMenuItem(child, plugin))
+		}
+	}
+	return &copied
+}
+
+// sortMenuItems orders every menu by weight, then title, then key
+func sortMenuItems(items []*MenuItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Weight != b.Weight {
+			return a.Weight < b.Weight
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.Key < b.Key
+	})
+	for _, item := range items {
+		sortMenuItems(item.Items)
+	}
+}
+
+// Menu merges the menu items of the loaded plugins, see MenuRegistry. Actions are run
+// like LoadedPlugin.Run, with the manifest's run timeout and panics returned as errors.
+// Enabled and Visible functions that panic are logged and report false. A plugin that
+// panics while contributing items is left out of the menu.
+func (m *Manager) Menu() *Menu {
+	registry := NewMenuRegistry()
+	for _, loaded := range m.Plugins() {
+		var items []*MenuItem
+		err := guardPanic(loaded.Name, func() error {
+			items = pluginMenuItems(loaded.Manifest, loaded.Plugin, func(ctx context.Context) error {
+				return RunPlugin(loaded.Plugin, loaded.Context(ctx))
+			})
+			return nil
+		})
+		if err != nil {
+			m.opts.Logger.Error("plugin failed to contribute menu items", "plugin", loaded.Name, "error", err)
+			continue
+		}
+		registry.Register(loaded.Name, guardMenuActions(items, loaded, m.opts.Logger))
+	}
+	return registry.Menu()
+}
+
+// guardMenuActions returns copies of items whose actions run like LoadedPlugin.Run and
+// whose Enabled and Visible functions report false, logging to logger, if they panic
+func guardMenuActions(items []*MenuItem, loaded *LoadedPlugin, logger *slog.Logger) []*MenuItem {
+	guarded := make([]*MenuItem, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		copied := *item
+		if action := item.Action; action != nil {
+			copied.Action = func(ctx context.Context) error { return loaded.call(ctx, "run", action) }
+		}
+		copied.Enabled = guardMenuPredicate(item.Enabled, loaded.Name, item.Key, "Enabled", logger)
+		copied.Visible = guardMenuPredicate(item.Visible, loaded.Name, item.Key, "Visible", logger)
+		copied.Items = guardMenuActions(item.Items, loaded, logger)
+		guarded = append(guarded, &copied)
+	}
+	return guarded
+}
+
+// guardMenuPredicate returns fn, named name, of the menu item key as a function that
+// reports false and logs to logger if fn panics
+func guardMenuPredicate(fn func() bool, plugin, key, name string, logger *slog.Logger) func() bool {
+	if fn == nil {
+		return nil
+	}
+	return func() bool {
+		var ok bool
+		err := guardPanic(plugin, func() error {
+			ok = fn()
+			return nil
+		})
+		if err != nil {
+			logger.Error("menu item "+name+" panicked", "plugin", plugin, "item", key, "error", err)
+			return false
+		}
+		return ok
+	}
+}
@@ -0,0 +1,243 @@
+package gsplug
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// action returns a menu item that runs something
+func action(key string) *MenuItem {
+	return &MenuItem{Key: key, Title: key, Action: func(ctx context.Context) error { return nil }}
+}
+
+// group returns a menu item that only opens a submenu
+func group(key string, items ...*MenuItem) *MenuItem {
+	return &MenuItem{Key: key, Title: key, Items: items}
+}
+
+// withHotkey sets the hotkey of item
+func withHotkey(item *MenuItem, hotkey string) *MenuItem {
+	item.Hotkey = hotkey
+	return item
+}
+
+// flattenMenu lists every item of a menu as its path of keys, followed by its hotkey if any
+func flattenMenu(items []*MenuItem, prefix string) []string {
+	var out []string
+	for _, item := range items {
+		line := prefix + item.Key
+		if item.Hotkey != "" {
+			line += " [" + item.Hotkey + "]"
+		}
+		out = append(out, line)
+		out = append(out, flattenMenu(item.Items, prefix+item.Key+"/")...)
+	}
+	return out
+}
+
+func TestMenuRegistryMenu(t *testing.T) {
+	tests := []struct {
+		name          string
+		reserve       []string
+		contributions map[string][]*MenuItem
+		wantItems     []string
+		wantConflicts []string
+	}{
+		{
+			name: "merges groups with the same key",
+			contributions: map[string][]*MenuItem{
+				"a": {group("git", action("status"))},
+				"b": {group("git", action("log"))},
+			},
+			wantItems: []string{"git", "git/log", "git/status"},
+		},
+		{
+			name: "renames colliding items inside merged groups",
+			contributions: map[string][]*MenuItem{
+				"a": {group("git", action("status"))},
+				"b": {group("git", action("status"))},
+			},
+			wantItems: []string{"git", "git/b.status", "git/status"},
+			wantConflicts: []string{
+				`plugin b: key "status" in menu git is taken by plugin a; renamed to "b.status"`,
+			},
+		},
+		{
+			name: "does not merge a group with an action",
+			contributions: map[string][]*MenuItem{
+				"a": {group("git", action("status"))},
+				"b": {action("git")},
+			},
+			wantItems: []string{"b.git", "git", "git/status"},
+			wantConflicts: []string{
+				`plugin b: key "git" is taken by plugin a; renamed to "b.git"`,
+			},
+		},
+		{
+			name: "keys collide ignoring case",
+			contributions: map[string][]*MenuItem{
+				"a": {action("Tools")},
+				"b": {action("tools")},
+			},
+			wantItems: []string{"Tools", "b.tools"},
+			wantConflicts: []string{
+				`plugin b: key "tools" is taken by plugin a; renamed to "b.tools"`,
+			},
+		},
+		{
+			name: "renames reserved keys",
+			contributions: map[string][]*MenuItem{
+				"a": {action("Config"), group("tools", action("config"))},
+			},
+			wantItems: []string{"a.Config", "tools", "tools/config"},
+			wantConflicts: []string{
+				`plugin a: key "Config" is taken by a built-in Gitspace entry; renamed to "a.Config"`,
+			},
+		},
+		{
+			name:    "renames keys reserved by the host",
+			reserve: []string{"deploy"},
+			contributions: map[string][]*MenuItem{
+				"a": {action("deploy")},
+			},
+			wantItems: []string{"a.deploy"},
+			wantConflicts: []string{
+				`plugin a: key "deploy" is taken by a built-in Gitspace entry; renamed to "a.deploy"`,
+			},
+		},
+		{
+			name: "numbers renamed keys that are taken too",
+			contributions: map[string][]*MenuItem{
+				"a": {action("run"), action("b.run")},
+				"b": {action("run")},
+			},
+			wantItems: []string{"b.run", "b.run-2", "run"},
+			wantConflicts: []string{
+				`plugin b: key "run" is taken by plugin a; renamed to "b.run-2"`,
+			},
+		},
+		{
+			name:    "numbers renamed keys that are reserved",
+			reserve: []string{"a.quit"},
+			contributions: map[string][]*MenuItem{
+				"a": {action("quit")},
+			},
+			wantItems: []string{"a.quit-2"},
+			wantConflicts: []string{
+				`plugin a: key "quit" is taken by a built-in Gitspace entry; renamed to "a.quit-2"`,
+			},
+		},
+		{
+			name: "hotkeys collide ignoring case",
+			contributions: map[string][]*MenuItem{
+				"a": {withHotkey(action("one"), "G")},
+				"b": {withHotkey(action("two"), "g")},
+			},
+			wantItems: []string{"one [G]", "two"},
+			wantConflicts: []string{
+				`plugin b: hotkey "g" is taken by plugin a; hotkey removed`,
+			},
+		},
+		{
+			name: "hotkeys of different menus do not collide",
+			contributions: map[string][]*MenuItem{
+				"a": {withHotkey(action("one"), "g"), group("git", withHotkey(action("grep"), "g"))},
+			},
+			wantItems: []string{"git", "git/grep [g]", "one [g]"},
+		},
+		{
+			name: "leaves out items without a key",
+			contributions: map[string][]*MenuItem{
+				"a": {action(""), nil, action("one")},
+			},
+			wantItems: []string{"one"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewMenuRegistry()
+			registry.Reserve(tt.reserve...)
+			for plugin, items := range tt.contributions {
+				registry.Register(plugin, items)
+			}
+
+			menu := registry.Menu()
+
+			if got := flattenMenu(menu.Items, ""); !reflect.DeepEqual(got, tt.wantItems) {
+				t.Errorf("items = %q, want %q", got, tt.wantItems)
+			}
+			var conflicts []string
+			for _, conflict := range menu.Conflicts {
+				conflicts = append(conflicts, conflict.String())
+			}
+			if !reflect.DeepEqual(conflicts, tt.wantConflicts) {
+				t.Errorf("conflicts = %q, want %q", conflicts, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestMenuRegistryMenuLeavesContributionsUnchanged(t *testing.T) {
+	a := []*MenuItem{group("git", withHotkey(action("status"), "s"))}
+	b := []*MenuItem{group("git", withHotkey(action("status"), "s"))}
+
+	registry := NewMenuRegistry()
+	registry.Register("a", a)
+	registry.Register("b", b)
+	menu := registry.Menu()
+
+	if got := menu.Find("GIT", "b.status"); got == nil || got.Plugin != "b" {
+		t.Fatalf("Find(GIT, b.status) = %+v, want the item of plugin b", got)
+	}
+	for _, items := range [][]*MenuItem{a, b} {
+		if got, want := flattenMenu(items, ""), []string{"git", "git/status [s]"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("contribution = %q after Menu, want %q", got, want)
+		}
+		if items[0].Plugin != "" {
+			t.Errorf("contribution attributed to plugin %q after Menu", items[0].Plugin)
+		}
+	}
+
+	registry.Unregister("b")
+	if got := strings.Join(flattenMenu(registry.Menu().Items, ""), ","); got != "git,git/status [s]" {
+		t.Errorf("items after Unregister = %q", got)
+	}
+}
+
+func TestGuardMenuActionsPredicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		predicate  func() bool
+		want       bool
+		wantLogged bool
+	}{
+		{name: "nil", want: true},
+		{name: "true", predicate: func() bool { return true }, want: true},
+		{name: "false", predicate: func() bool { return false }},
+		{name: "panics", predicate: func() bool { panic("boom") }, wantLogged: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+			item := &MenuItem{Key: "hello", Enabled: tt.predicate, Visible: tt.predicate}
+
+			guarded := guardMenuActions([]*MenuItem{item}, &LoadedPlugin{Name: "p"}, logger)[0]
+			if got := guarded.IsEnabled(); got != tt.want {
+				t.Errorf("IsEnabled() = %v, want %v", got, tt.want)
+			}
+			if got := guarded.IsVisible(); got != tt.want {
+				t.Errorf("IsVisible() = %v, want %v", got, tt.want)
+			}
+			if logged := strings.Contains(logs.String(), "boom"); logged != tt.wantLogged {
+				t.Errorf("logged panic = %v, want %v; logs:\n%s", logged, tt.wantLogged, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,604 @@
+package gsplug
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A plugin package is a gzip-compressed tar archive named
+// <name>-<version>-<goos>-<goarch>.tar.gz holding a single <name>-<version>/ directory:
+//
+//	gitspace-plugin.toml   the plugin manifest
+//	gsplug-package.json    PackageInfo: the platform, toolchain and artifacts
+//	<artifact>...          the built plugin (.so) and/or binary, next to the manifest
+//	<artifact>.sig...      the artifacts' signatures, if they were signed
+//	README*, LICENSE*...   documentation copied from the plugin directory, if present
+//	SHA256SUMS             the SHA-256 of every other file, in sha256sum format
+const (
+	// PackageExt is the file name extension of plugin packages
+	PackageExt = ".tar.gz"
+	// PackageInfoFile describes the contents of a package
+	PackageInfoFile = "gsplug-package.json"
+	// PackageChecksumFile lists the checksum of every other file in a package
+	PackageChecksumFile = "SHA256SUMS"
+	// PackageFormatVersion is the version of the package layout written by Package
+	PackageFormatVersion = 1
+)
+
+// ErrInvalidPackage is returned by ReadPackage for archives that do not follow the
+// package layout or whose checksums do not match
+var ErrInvalidPackage = errors.New("invalid plugin package")
+
+// packageDocPrefixes are the upper-cased name prefixes of files copied from the plugin
+// directory into its package
+var packageDocPrefixes = []string{"README", "LICENSE", "LICENCE", "COPYING", "NOTICE"}
+
+// PackageInfo is the gsplug-package.json file of a package
+type PackageInfo struct {
+	FormatVersion int    `json:"format_version"`
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	// Platform is the GOOS/GOARCH the artifacts were built for
+	Platform string `json:"platform"`
+	// Toolchain is the Go version that built the artifacts
+	Toolchain string `json:"toolchain"`
+	// HostToolchain is the Gitspace toolchain a Go plugin was checked against
+	HostToolchain string            `json:"host_toolchain,omitempty"`
+	Artifacts     []PackageArtifact `json:"artifacts"`
+	// BuiltAt is when the artifacts were built
+	BuiltAt time.Time `json:"built_at"`
+}
+
+// PackageArtifact is a built artifact in a package
+type PackageArtifact struct {
+	// Mode is BuildModePlugin or BuildModeBinary
+	Mode string `json:"mode"`
+	// Path is the file name relative to the package directory
+	Path string `json:"path"`
+	// Signature is the file name of the artifact's signature, if it was signed
+	Signature string `json:"signature,omitempty"`
+	// Provenance is the file name of the artifact's provenance, see Provenance
+	Provenance string `json:"provenance,omitempty"`
+}
+
+// PackageFile is a file in a package
+type PackageFile struct {
+	// Name is the path relative to the package directory
+	Name   string
+	Size   int64
+	Mode   fs.FileMode
+	SHA256 string
+}
+
+// Package is the verified contents of a plugin package, as returned by ReadPackage
+type Package struct {
+	// Path is the archive that was read
+	Path string
+	// Root is the directory every file in the archive is stored under
+	Root     string
+	Info     PackageInfo
+	Manifest *PluginManifest
+	// Files lists every file in the package, sorted by name
+	Files []PackageFile
+}
+
+// PackageOptions controls how Package builds and writes a plugin package
+type PackageOptions struct {
+	// OutputDir is where the archive is written. Defaults to the directory holding the
+	// plugin's first artifact, usually dist/.
+	OutputDir string
+	// Build controls the build that runs before packaging. Of its fields, only Force,
+	// Runner, Toolchain and Reproducible apply.
+	Build BuildOptions
+}
+
+// PackagePlugin builds and packages the plugin in pluginDir using the default configuration
+func PackagePlugin(pluginDir string) (string, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return "", err
+	}
+	return cfg.Package(context.Background(), pluginDir, PackageOptions{})
+}
+
+// Package builds the plugin in pluginDir, unless it is up to date, and writes a package
+// holding its artifacts, manifest and documentation. It returns the path of the archive.
+func (c *Config) Package(ctx context.Context, pluginDir string, opts PackageOptions) (string, error) {
+	manifestPath := filepath.Join(pluginDir, ManifestFileName)
+	diags, err := ValidateManifestFile(manifestPath, ValidateOptions{Dir: pluginDir})
+	if err != nil {
+		return "", fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return "", fmt.Errorf("invalid plugin manifest %s:%s", manifestPath, d)
+		}
+	}
+
+	result := c.Build(ctx, pluginDir, opts.Build)
+	if result.Err != nil {
+		return "", result.Err
+	}
+
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	record, err := readBuildRecord(pluginDir, result.Artifacts)
+	if err != nil {
+		return "", fmt.Errorf("failed to read build record: %w", err)
+	}
+
+	info := PackageInfo{
+		FormatVersion: PackageFormatVersion,
+		Name:          manifest.Metadata.Name,
+		Version:       manifest.Metadata.Version,
+		Platform:      record.Platform,
+		Toolchain:     record.Toolchain,
+		HostToolchain: record.HostToolchain,
+		BuiltAt:       record.BuiltAt,
+	}
+
+	// Every file is stored at the top of the package directory, keyed by its name there
+	sources := map[string]string{ManifestFileName: manifestPath}
+	for _, artifact := range result.Artifacts {
+		name := filepath.Base(artifact.Path)
+		if _, ok := sources[name]; ok {
+			return "", fmt.Errorf("artifact %s conflicts with another file in the package", artifact.Path)
+		}
+		sources[name] = artifact.Path
+		packaged := PackageArtifact{Mode: artifact.Mode, Path: name}
+
+		// Building removes stale signatures, so one that is present was made for this build
+		if _, err := os.Stat(artifact.Path + SignatureExt); err == nil {
+			packaged.Signature = name + SignatureExt
+			sources[packaged.Signature] = artifact.Path + SignatureExt
+		}
+		if _, err := os.Stat(artifact.Path + ProvenanceExt); err == nil {
+			packaged.Provenance = name + ProvenanceExt
+			sources[packaged.Provenance] = artifact.Path + ProvenanceExt
+		}
+		info.Artifacts = append(info.Artifacts, packaged)
+	}
+
+	docs, err := packageDocs(pluginDir)
+	if err != nil {
+		return "", err
+	}
+	for _, doc := range docs {
+		if _, ok := sources[doc]; !ok {
+			sources[doc] = filepath.Join(pluginDir, doc)
+		}
+	}
+
+	infoData, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	infoData = append(infoData, '\n')
+
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = filepath.Dir(result.Artifacts[0].Path)
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", err
+	}
+	archivePath := filepath.Join(outputDir, PackageFileName(info.Name, info.Version, info.Platform))
+
+	if err := writePackage(archivePath, info.Name+"-"+info.Version, sources, infoData, record.BuiltAt); err != nil {
+		return "", fmt.Errorf("failed to write package: %w", err)
+	}
+
+	return archivePath, nil
+}
+
+// PackageFileName returns the file name of the package of a plugin version built for
+// platform, a GOOS/GOARCH pair
+func PackageFileName(name, version, platform string) string {
+	return fmt.Sprintf("%s-%s-%s%s", name, version, strings.ReplaceAll(platform, "/", "-"), PackageExt)
+}
+
+// packageDocs returns the names of the documentation files in pluginDir, such as README.md
+// and LICENSE
+func packageDocs(pluginDir string) ([]string, error) {
+	entries, err := os.ReadDir(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		upper := strings.ToUpper(entry.Name())
+		for _, prefix := range packageDocPrefixes {
+			if strings.HasPrefix(upper, prefix) {
+				docs = append(docs, entry.Name())
+				break
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+// writePackage writes the files in sources, the package info and their checksums under
+// root in a new archive at archivePath, dating every entry builtAt so that packaging the
+// same build twice gives the same bytes. The archive is written to a temporary file first,
+// so a failed or interrupted write never leaves a truncated package behind.
+func writePackage(archivePath, root string, sources map[string]string, infoData []byte, builtAt time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(archivePath), ".gsplug-package-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// CreateTemp makes the file private to the user
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	modTime := builtAt.UTC().Truncate(time.Second)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     root + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+
+	writeFile := func(name string, mode int64, r io.Reader, size int64) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     root + "/" + name,
+			Mode:     mode,
+			Size:     size,
+			ModTime:  modTime,
+		}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	checksums := map[string]string{PackageInfoFile: fmt.Sprintf("%x", sha256.Sum256(infoData))}
+	for _, name := range names {
+		f, err := os.Open(sources[name])
+		if err != nil {
+			return err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		// Executables keep their permission bits so binaries can be run in place
+		mode := int64(0644)
+		if stat.Mode()&0111 != 0 {
+			mode = 0755
+		}
+
+		h := sha256.New()
+		err = writeFile(name, mode, io.TeeReader(f, h), stat.Size())
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to add %s: %w", sources[name], err)
+		}
+		checksums[name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	if err := writeFile(PackageInfoFile, 0644, bytes.NewReader(infoData), int64(len(infoData))); err != nil {
+		return err
+	}
+
+	sums := formatChecksums(checksums)
+	if err := writeFile(PackageChecksumFile, 0644, bytes.NewReader(sums), int64(len(sums))); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), archivePath)
+}
+
+// formatChecksums renders checksums, keyed by file name, in the format of sha256sum
+func formatChecksums(checksums map[string]string) []byte {
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s  %s\n", checksums[name], name)
+	}
+	return b.Bytes()
+}
+
+// parseChecksums parses a file in the format of sha256sum
+func parseChecksums(data []byte) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s line %d is malformed", PackageChecksumFile, line)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, fmt.Errorf("%s line %d is malformed", PackageChecksumFile, line)
+		}
+		if _, ok := checksums[name]; ok {
+			return nil, fmt.Errorf("%s lists %s twice", PackageChecksumFile, name)
+		}
+		checksums[name] = sum
+	}
+	return checksums, scanner.Err()
+}
+
+// ReadPackage reads the plugin package at path and verifies its layout and checksums.
+// Errors about the contents of the archive wrap ErrInvalidPackage.
+func ReadPackage(path string) (*Package, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+	}
+	defer gz.Close()
+
+	pkg := &Package{Path: path}
+	// The manifest, info and checksums are small and kept to be parsed once all files are read
+	special := map[string][]byte{ManifestFileName: nil, PackageInfoFile: nil, PackageChecksumFile: nil}
+	seen := make(map[string]bool)
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+		}
+
+		root, name, err := splitPackagePath(header.Name)
+		if err != nil {
+			return nil, err
+		}
+		if pkg.Root == "" {
+			pkg.Root = root
+		} else if root != pkg.Root {
+			return nil, fmt.Errorf("%w: %s is outside the package directory %s", ErrInvalidPackage, header.Name, pkg.Root)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if name != "" {
+				return nil, fmt.Errorf("%w: unexpected directory %s", ErrInvalidPackage, header.Name)
+			}
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, fmt.Errorf("%w: %s is not a regular file", ErrInvalidPackage, header.Name)
+		}
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("%w: unexpected file %s", ErrInvalidPackage, header.Name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%w: %s appears twice", ErrInvalidPackage, header.Name)
+		}
+		seen[name] = true
+
+		h := sha256.New()
+		var r io.Reader = io.TeeReader(tr, h)
+		if _, ok := special[name]; ok {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+			}
+			special[name] = data
+		} else if _, err := io.Copy(io.Discard, r); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+		}
+
+		pkg.Files = append(pkg.Files, PackageFile{
+			Name:   name,
+			Size:   header.Size,
+			Mode:   header.FileInfo().Mode(),
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+
+	for name, data := range special {
+		if data == nil {
+			return nil, fmt.Errorf("%w: %s is missing", ErrInvalidPackage, name)
+		}
+	}
+
+	checksums, err := parseChecksums(special[PackageChecksumFile])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+	}
+	for _, file := range pkg.Files {
+		if file.Name == PackageChecksumFile {
+			continue
+		}
+		sum, ok := checksums[file.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not listed in %s", ErrInvalidPackage, file.Name, PackageChecksumFile)
+		}
+		if sum != file.SHA256 {
+			return nil, fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidPackage, file.Name)
+		}
+		delete(checksums, file.Name)
+	}
+	if len(checksums) > 0 {
+		missing := make([]string, 0, len(checksums))
+		for name := range checksums {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %s lists missing files %s", ErrInvalidPackage, PackageChecksumFile, strings.Join(missing, ", "))
+	}
+
+	if err := json.Unmarshal(special[PackageInfoFile], &pkg.Info); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPackage, PackageInfoFile, err)
+	}
+	if pkg.Info.FormatVersion != PackageFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported package format version %d", ErrInvalidPackage, pkg.Info.FormatVersion)
+	}
+	if pkg.Manifest, err = ParseManifest(special[ManifestFileName]); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidPackage, ManifestFileName, err)
+	}
+	if pkg.Manifest.Metadata.Name != pkg.Info.Name || pkg.Manifest.Metadata.Version != pkg.Info.Version {
+		return nil, fmt.Errorf("%w: manifest describes %s %s but the package holds %s %s", ErrInvalidPackage,
+			pkg.Manifest.Metadata.Name, pkg.Manifest.Metadata.Version, pkg.Info.Name, pkg.Info.Version)
+	}
+	if len(pkg.Info.Artifacts) == 0 {
+		return nil, fmt.Errorf("%w: no artifacts", ErrInvalidPackage)
+	}
+	for _, artifact := range pkg.Info.Artifacts {
+		if !seen[artifact.Path] {
+			return nil, fmt.Errorf("%w: artifact %s is missing", ErrInvalidPackage, artifact.Path)
+		}
+		if artifact.Signature != "" && !seen[artifact.Signature] {
+			return nil, fmt.Errorf("%w: signature %s is missing", ErrInvalidPackage, artifact.Signature)
+		}
+		if artifact.Provenance != "" && !seen[artifact.Provenance] {
+			return nil, fmt.Errorf("%w: provenance %s is missing", ErrInvalidPackage, artifact.Provenance)
+		}
+	}
+
+	sort.Slice(pkg.Files, func(i, j int) bool { return pkg.Files[i].Name < pkg.Files[j].Name })
+	return pkg, nil
+}
+
+// Extract writes the files of the package into dir, which must exist. Each file is
+// checked against the checksum ReadPackage verified, in case the archive changed since.
+func (p *Package) Extract(dir string) error {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+	}
+	defer gz.Close()
+
+	files := make(map[string]PackageFile, len(p.Files))
+	for _, file := range p.Files {
+		files[file.Name] = file
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPackage, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		_, name, err := splitPackagePath(header.Name)
+		if err != nil {
+			return err
+		}
+		file, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%w: %s changed since it was read", ErrInvalidPackage, p.Path)
+		}
+
+		if err := extractFile(filepath.Join(dir, name), tr, file); err != nil {
+			return err
+		}
+		delete(files, name)
+	}
+
+	if len(files) > 0 {
+		return fmt.Errorf("%w: %s changed since it was read", ErrInvalidPackage, p.Path)
+	}
+	return nil
+}
+
+// extractFile writes r to path with the mode of file, failing if its checksum differs
+func extractFile(path string, r io.Reader, file PackageFile) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, file.Mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("%w: checksum mismatch for %s", ErrInvalidPackage, file.Name)
+	}
+	return nil
+}
+
+// splitPackagePath splits the name of an archive entry into the package directory and
+// the path below it, rejecting absolute paths and paths that escape the directory
+func splitPackagePath(name string) (root, rest string, err error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, `\`) {
+		return "", "", fmt.Errorf("%w: unsafe path %s", ErrInvalidPackage, name)
+	}
+	root, rest, _ = strings.Cut(clean, "/")
+	return root, rest, nil
+}
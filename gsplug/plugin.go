@@ -0,0 +1,215 @@
+package gsplug
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"reflect"
+	"strings"
+)
+
+const (
+	// ManifestFileName is the name of the manifest every plugin ships with
+	ManifestFileName = "gitspace-plugin.toml"
+
+	// DefaultEntryPoint is the symbol looked up when the manifest does not name one
+	DefaultEntryPoint = "Plugin"
+)
+
+// Plugin is the contract between Gitspace and a plugin.
+//
+// A plugin exports a variable (named by sources.entry_point in its manifest)
+// whose address implements this interface, for example:
+//
+//	var Plugin MyPlugin
+//	var _ gsplug.Plugin = (*MyPlugin)(nil)
+//
+// Go plugins must also export the API version they were built against; see APIVersionSymbol.
+type Plugin interface {
+	Init() error
+	Name() string
+	Version() string
+	Description() string
+	Run() error
+	GetMenuOption() *Option
+}
+
+// Shutdowner is implemented by plugins that need to release resources before Gitspace exits
+type Shutdowner interface {
+	Shutdown() error
+}
+
+// Configurable is implemented by plugins that accept settings from the host
+type Configurable interface {
+	Configure(config map[string]interface{}) error
+}
+
+// InvalidPluginError is returned when a plugin's entry point does not implement Plugin
+type InvalidPluginError struct {
+	Symbol  string
+	Type    string
+	Reasons []string
+}
+
+func (e *InvalidPluginError) Error() string {
+	return fmt.Sprintf("symbol %s has type %s which does not implement gsplug.Plugin: %s",
+		e.Symbol, e.Type, strings.Join(e.Reasons, "; "))
+}
+
+// EntryPoint returns the name of the symbol the host should look up in the plugin
+func (m *PluginManifest) EntryPoint() string {
+	for _, source := range m.Sources {
+		if source.EntryPoint != "" {
+			return source.EntryPoint
+		}
+	}
+	return DefaultEntryPoint
+}
+
+// LoadPlugin opens a plugin built with -buildmode=plugin and returns its entry point.
+// The manifest is read from the artifact's directory, or from its parent when the
+// artifact lives in the plugin's dist directory.
+func LoadPlugin(path string) (Plugin, error) {
+	manifestPath, err := findManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := ReadManifest(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+
+	return LoadPluginWithManifest(path, manifest)
+}
+
+// LoadPluginWithManifest opens a plugin and looks up the entry point named by the given manifest.
+// The plugin's API version is negotiated first and the plugin is refused if it is incompatible.
+func LoadPluginWithManifest(path string, manifest *PluginManifest) (Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin %s: %w", path, err)
+	}
+
+	apiVersion, err := pluginAPIVersion(p)
+	if err != nil {
+		return nil, err
+	}
+	negotiation, err := NegotiateAPIVersion(PluginAPIVersion, apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	if negotiation.Adapted {
+		return adaptPlugin(p, manifest.EntryPoint(), negotiation)
+	}
+
+	return lookupPlugin(p, manifest.EntryPoint())
+}
+
+// findManifest locates the manifest belonging to a plugin artifact
+func findManifest(artifactPath string) (string, error) {
+	dir := filepath.Dir(artifactPath)
+	candidates := []string{
+		filepath.Join(dir, ManifestFileName),
+		filepath.Join(filepath.Dir(dir), ManifestFileName),
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s not found next to plugin %s", ManifestFileName, artifactPath)
+}
+
+// lookupPlugin resolves the named symbol and checks that it implements Plugin
+func lookupPlugin(p *plugin.Plugin, name string) (Plugin, error) {
+	sym, err := p.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("plugin does not export entry point %q: %w", name, err)
+	}
+
+	switch v := sym.(type) {
+	case Plugin:
+		return v, nil
+	case *Plugin:
+		// The plugin declared its entry point as `var Plugin gsplug.Plugin = ...`
+		if *v == nil {
+			return nil, fmt.Errorf("entry point %q is a nil gsplug.Plugin", name)
+		}
+		return *v, nil
+	}
+
+	t := reflect.TypeOf(sym)
+	return nil, &InvalidPluginError{
+		Symbol:  name,
+		Type:    t.String(),
+		Reasons: missingMethods(t, reflect.TypeOf((*Plugin)(nil)).Elem()),
+	}
+}
+
+// missingMethods describes every method of iface that t lacks or declares with the wrong signature
+func missingMethods(t, iface reflect.Type) []string {
+	var reasons []string
+	for i := 0; i < iface.NumMethod(); i++ {
+		want := iface.Method(i)
+		got, ok := t.MethodByName(want.Name)
+		if !ok {
+			if t.Kind() != reflect.Ptr {
+				if _, ok := reflect.PointerTo(t).MethodByName(want.Name); ok {
+					reasons = append(reasons, fmt.Sprintf("method %s has a pointer receiver", want.Name))
+					continue
+				}
+			}
+			reasons = append(reasons, fmt.Sprintf("missing method %s", want.Name))
+			continue
+		}
+		if !sameSignature(got.Type, want.Type) {
+			reasons = append(reasons, fmt.Sprintf("method %s has signature %s, want %s",
+				want.Name, methodSignature(got.Type), want.Type))
+		}
+	}
+	return reasons
+}
+
+// sameSignature compares a concrete method type (receiver first) with an interface method type
+func sameSignature(method, want reflect.Type) bool {
+	if method.NumIn()-1 != want.NumIn() || method.NumOut() != want.NumOut() {
+		return false
+	}
+	for i := 0; i < want.NumIn(); i++ {
+		if method.In(i+1) != want.In(i) {
+			return false
+		}
+	}
+	for i := 0; i < want.NumOut(); i++ {
+		if method.Out(i) != want.Out(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// methodSignature formats a concrete method type without its receiver
+func methodSignature(method reflect.Type) string {
+	in := make([]string, 0, method.NumIn())
+	for i := 1; i < method.NumIn(); i++ {
+		in = append(in, method.In(i).String())
+	}
+	out := make([]string, 0, method.NumOut())
+	for i := 0; i < method.NumOut(); i++ {
+		out = append(out, method.Out(i).String())
+	}
+
+	sig := "func(" + strings.Join(in, ", ") + ")"
+	switch len(out) {
+	case 0:
+	case 1:
+		sig += " " + out[0]
+	default:
+		sig += " (" + strings.Join(out, ", ") + ")"
+	}
+	return sig
+}
@@ -0,0 +1,631 @@
+package gsplug
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Types of the settings declared by [[config]] entries of a plugin manifest
+const (
+	ConfigTypeString   = "string"
+	ConfigTypeInt      = "int"
+	ConfigTypeFloat    = "float"
+	ConfigTypeBool     = "bool"
+	ConfigTypeDuration = "duration"
+	// ConfigTypeList is a list of strings. In environment variables its items are
+	// separated by commas.
+	ConfigTypeList = "list"
+)
+
+// Sources of a plugin setting's value, in increasing order of precedence
+const (
+	ConfigSourceDefault = "default"
+	ConfigSourceFile    = "file"
+	ConfigSourceEnv     = "env"
+)
+
+// PluginConfigEnvPrefix starts the environment variables overriding plugin settings, see
+// PluginConfigEnv
+const PluginConfigEnvPrefix = "GITSPACE_PLUGIN_"
+
+// ConfigField declares a plugin setting, in a [[config]] entry of the manifest
+type ConfigField struct {
+	// Name is the setting's key, e.g. api_url
+	Name string `toml:"name"`
+	// Type is one of the ConfigType constants. Defaults to string.
+	Type        string `toml:"type,omitempty"`
+	Default     any    `toml:"default,omitempty"`
+	Required    bool   `toml:"required,omitempty"`
+	Description string `toml:"description,omitempty"`
+	// Secret settings, such as API tokens, are masked when listed
+	Secret bool `toml:"secret,omitempty"`
+	// Enum, if set, holds the values the setting accepts. For lists it holds the values
+	// their items accept.
+	Enum []any `toml:"enum,omitempty"`
+}
+
+// PluginConfig is the configuration of a plugin, resolved against the [[config]] schema of
+// its manifest. Each setting takes, in increasing order of precedence, its default, the
+// value in the user's config file for the plugin and the value of its environment variable.
+// Values are string, int64, float64, bool, time.Duration or []string, as the type says.
+type PluginConfig struct {
+	// Plugin is the plugin's name and Path its config file
+	Plugin string
+	Path   string
+
+	schema  []ConfigField
+	values  map[string]any
+	sources map[string]string
+}
+
+// ConfigSetting is a setting of a PluginConfig
+type ConfigSetting struct {
+	Field ConfigField
+	// Value is nil if the setting is not set
+	Value any
+	// Source is one of the ConfigSource constants, or empty if the setting is not set
+	Source string
+	// Env is the environment variable overriding the setting
+	Env string
+}
+
+// PluginConfigPath returns the user's config file for the named plugin
+func (c *Config) PluginConfigPath(name string) string {
+	return filepath.Join(c.HomeDir(), "plugin-config", name+".toml")
+}
+
+// PluginConfigEnv returns the environment variable overriding a plugin's setting, e.g.
+// GITSPACE_PLUGIN_MY_PLUGIN_API_URL for the setting api_url of my-plugin
+func PluginConfigEnv(plugin, setting string) string {
+	return PluginConfigEnvPrefix + envName(plugin) + "_" + envName(setting)
+}
+
+// envName upper-cases s and replaces what environment variable names cannot hold with _
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// LoadPluginConfig resolves the configuration of the plugin with the given manifest, using
+// the default configuration
+func LoadPluginConfig(manifest *PluginManifest) (*PluginConfig, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.LoadPluginConfig(manifest)
+}
+
+// LoadPluginConfig resolves the configuration of the plugin with the given manifest. It
+// fails if a value does not match its setting's type, but not if a required setting is
+// missing; see PluginConfig.Validate. Settings in the config file that the schema does not
+// declare are ignored.
+func (c *Config) LoadPluginConfig(manifest *PluginManifest) (*PluginConfig, error) {
+	name := manifest.Metadata.Name
+	conf := &PluginConfig{
+		Plugin:  name,
+		Path:    c.PluginConfigPath(name),
+		schema:  manifest.Config,
+		values:  make(map[string]any),
+		sources: make(map[string]string),
+	}
+
+	file, err := readPluginConfigFile(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	set := func(field ConfigField, value any, source, origin string) {
+		coerced, err := field.coerce(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("setting %s from %s: %w", field.Name, origin, err))
+			return
+		}
+		conf.values[field.Name] = coerced
+		conf.sources[field.Name] = source
+	}
+	for _, field := range manifest.Config {
+		if field.Default != nil {
+			set(field, field.Default, ConfigSourceDefault, ManifestFileName)
+		}
+		if value, ok := file[field.Name]; ok {
+			set(field, value, ConfigSourceFile, conf.Path)
+		}
+		env := PluginConfigEnv(name, field.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			parsed, err := field.parse(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("setting %s from %s: %w", field.Name, env, err))
+				continue
+			}
+			set(field, parsed, ConfigSourceEnv, env)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration for plugin %s: %w", name, errors.Join(errs...))
+	}
+
+	return conf, nil
+}
+
+// SetPluginConfig parses value as the named setting of the plugin with the given manifest
+// and writes it to the user's config file for the plugin
+func (c *Config) SetPluginConfig(manifest *PluginManifest, setting, value string) error {
+	field, ok := manifest.configField(setting)
+	if !ok {
+		return fmt.Errorf("plugin %s has no setting %q", manifest.Metadata.Name, setting)
+	}
+	parsed, err := field.parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", setting, err)
+	}
+	parsed, err = field.coerce(parsed)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", setting, err)
+	}
+	if duration, ok := parsed.(time.Duration); ok {
+		parsed = duration.String()
+	}
+
+	path := c.PluginConfigPath(manifest.Metadata.Name)
+	file, err := readPluginConfigFile(path)
+	if err != nil {
+		return err
+	}
+	file[setting] = parsed
+
+	data, err := toml.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// The file may hold secrets
+	return writeFileAtomic(path, data, 0600)
+}
+
+// readPluginConfigFile reads a plugin's config file, which may not exist
+func readPluginConfigFile(path string) (map[string]any, error) {
+	file := make(map[string]any)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := toml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// newPluginConfig returns the configuration holding values, as sent to a plugin process
+func newPluginConfig(manifest *PluginManifest, values map[string]any) (*PluginConfig, error) {
+	conf := &PluginConfig{
+		Plugin:  manifest.Metadata.Name,
+		schema:  manifest.Config,
+		values:  make(map[string]any),
+		sources: make(map[string]string),
+	}
+	for _, field := range manifest.Config {
+		value, ok := values[field.Name]
+		if !ok {
+			continue
+		}
+		coerced, err := field.coerce(value)
+		if err != nil {
+			return nil, fmt.Errorf("setting %s: %w", field.Name, err)
+		}
+		conf.values[field.Name] = coerced
+	}
+	return conf, nil
+}
+
+// Get returns the value of the named setting
+func (conf *PluginConfig) Get(name string) (any, bool) {
+	value, ok := conf.values[name]
+	return value, ok
+}
+
+// Values returns the settings that are set, keyed by name
+func (conf *PluginConfig) Values() map[string]any {
+	values := make(map[string]any, len(conf.values))
+	for name, value := range conf.values {
+		values[name] = value
+	}
+	return values
+}
+
+// Settings returns every setting of the schema, in the order of the manifest
+func (conf *PluginConfig) Settings() []ConfigSetting {
+	settings := make([]ConfigSetting, 0, len(conf.schema))
+	for _, field := range conf.schema {
+		settings = append(settings, ConfigSetting{
+			Field:  field,
+			Value:  conf.values[field.Name],
+			Source: conf.sources[field.Name],
+			Env:    PluginConfigEnv(conf.Plugin, field.Name),
+		})
+	}
+	return settings
+}
+
+// Validate checks that every required setting is set
+func (conf *PluginConfig) Validate() error {
+	var missing []string
+	for _, field := range conf.schema {
+		if _, ok := conf.values[field.Name]; field.Required && !ok {
+			missing = append(missing, field.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("plugin %s is missing required settings: %s (set them with `gsplug config set %s <setting> <value>`)",
+			conf.Plugin, strings.Join(missing, ", "), conf.Plugin)
+	}
+	return nil
+}
+
+// Decode validates the configuration and stores it in the struct v points to, or in the
+// map[string]any it points to. A setting goes to the field whose config or toml tag names
+// it, or else to the field whose name matches it ignoring case and underscores, so that
+// api_url fills APIURL. Fields of settings that are not set are left alone.
+func (conf *PluginConfig) Decode(v any) error {
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("cannot decode plugin configuration into %T, want a non-nil pointer", v)
+	}
+	target = target.Elem()
+
+	if values, ok := v.(*map[string]any); ok {
+		if *values == nil {
+			*values = make(map[string]any)
+		}
+		for name, value := range conf.values {
+			(*values)[name] = value
+		}
+		return nil
+	}
+	if target.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode plugin configuration into %T, want a pointer to a struct", v)
+	}
+
+	names := make([]string, 0, len(conf.values))
+	for name := range conf.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field, ok := configStructField(target, name)
+		if !ok {
+			continue
+		}
+		if err := assignConfigValue(field, conf.values[name]); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// configStructField returns the field of the struct s that receives the named setting
+func configStructField(s reflect.Value, name string) (reflect.Value, bool) {
+	t := s.Type()
+	normalized := strings.ReplaceAll(name, "_", "")
+	var match reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag, ok := field.Tag.Lookup("config")
+		if !ok {
+			tag, ok = field.Tag.Lookup("toml")
+		}
+		tag, _, _ = strings.Cut(tag, ",")
+		switch {
+		case tag == "-":
+		case ok && tag != "":
+			if tag == name {
+				return s.Field(i), true
+			}
+		case strings.EqualFold(field.Name, normalized) && !match.IsValid():
+			match = s.Field(i)
+		}
+	}
+	return match, match.IsValid()
+}
+
+// assignConfigValue stores a setting's value in field, converting it to the field's type
+func assignConfigValue(field reflect.Value, value any) error {
+	v := reflect.ValueOf(value)
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+
+	switch value := value.(type) {
+	case int64:
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if field.OverflowInt(value) {
+				return fmt.Errorf("%d overflows %s", value, field.Type())
+			}
+			field.SetInt(value)
+			return nil
+		case reflect.Float32, reflect.Float64:
+			field.SetFloat(float64(value))
+			return nil
+		}
+	case float64:
+		if field.Kind() == reflect.Float32 || field.Kind() == reflect.Float64 {
+			field.SetFloat(value)
+			return nil
+		}
+	case time.Duration:
+		switch field.Kind() {
+		case reflect.Int64:
+			field.SetInt(int64(value))
+			return nil
+		case reflect.String:
+			field.SetString(value.String())
+			return nil
+		}
+	}
+	if v.Type().ConvertibleTo(field.Type()) && v.Kind() == field.Kind() {
+		field.Set(v.Convert(field.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("cannot decode %T into a field of type %s", value, field.Type())
+}
+
+// configField returns the setting of the manifest's schema with the given name
+func (m *PluginManifest) configField(name string) (ConfigField, bool) {
+	for _, field := range m.Config {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return ConfigField{}, false
+}
+
+// EffectiveType returns the field's type, defaulting to string
+func (f ConfigField) EffectiveType() string {
+	if f.Type == "" {
+		return ConfigTypeString
+	}
+	return f.Type
+}
+
+// parse parses the text form of a value of the field, as found in environment variables
+// and on the command line
+func (f ConfigField) parse(s string) (any, error) {
+	var value any
+	var err error
+	switch f.EffectiveType() {
+	case ConfigTypeString:
+		return s, nil
+	case ConfigTypeInt:
+		value, err = strconv.ParseInt(s, 10, 64)
+	case ConfigTypeFloat:
+		value, err = strconv.ParseFloat(s, 64)
+	case ConfigTypeBool:
+		value, err = strconv.ParseBool(s)
+	case ConfigTypeDuration:
+		value, err = time.ParseDuration(s)
+	case ConfigTypeList:
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", f.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid %s", s, f.EffectiveType())
+	}
+	return value, nil
+}
+
+// coerce converts a value decoded from TOML or JSON, or parsed by parse, to the Go type of
+// the field's type, and checks it against the field's enum
+func (f ConfigField) coerce(value any) (any, error) {
+	coerced, err := coerceConfigValue(f.EffectiveType(), value)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Enum) == 0 {
+		return coerced, nil
+	}
+
+	enum, err := f.enumValues()
+	if err != nil {
+		return nil, err
+	}
+	check := []any{coerced}
+	if items, ok := coerced.([]string); ok {
+		check = check[:0]
+		for _, item := range items {
+			check = append(check, item)
+		}
+	}
+	for _, value := range check {
+		if !containsConfigValue(enum, value) {
+			return nil, fmt.Errorf("%v is not one of %s", formatConfigValue(value), formatConfigValue(enum))
+		}
+	}
+	return coerced, nil
+}
+
+// enumValues returns the field's enum converted to the Go type of its values, or of the
+// items of its lists
+func (f ConfigField) enumValues() ([]any, error) {
+	typ := f.EffectiveType()
+	if typ == ConfigTypeList {
+		typ = ConfigTypeString
+	}
+	enum := make([]any, 0, len(f.Enum))
+	for _, value := range f.Enum {
+		coerced, err := coerceConfigValue(typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("enum value %v: %w", formatConfigValue(value), err)
+		}
+		enum = append(enum, coerced)
+	}
+	return enum, nil
+}
+
+// coerceConfigValue converts value to the Go type of the setting type typ
+func coerceConfigValue(typ string, value any) (any, error) {
+	switch typ {
+	case ConfigTypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case ConfigTypeInt:
+		switch n := value.(type) {
+		case int64:
+			return n, nil
+		case int:
+			return int64(n), nil
+		case float64:
+			// JSON decodes every number as a float64
+			if n == math.Trunc(n) && math.Abs(n) < 1<<63 {
+				return int64(n), nil
+			}
+		}
+	case ConfigTypeFloat:
+		switch n := value.(type) {
+		case float64:
+			return n, nil
+		case int64:
+			return float64(n), nil
+		case int:
+			return float64(n), nil
+		}
+	case ConfigTypeBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case ConfigTypeDuration:
+		switch d := value.(type) {
+		case time.Duration:
+			return d, nil
+		case string:
+			duration, err := time.ParseDuration(d)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a duration such as \"30s\" or \"5m\"", d)
+			}
+			return duration, nil
+		}
+	case ConfigTypeList:
+		switch items := value.(type) {
+		case []string:
+			return append([]string{}, items...), nil
+		case []any:
+			list := make([]string, 0, len(items))
+			for _, item := range items {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a list of strings, found an item of type %s", configValueType(item))
+				}
+				list = append(list, s)
+			}
+			return list, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	return nil, fmt.Errorf("expected %s, found %s", typ, configValueType(value))
+}
+
+// configValueType names the type of a decoded value in errors
+func configValueType(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int, int64:
+		return "int"
+	case float64:
+		return "float"
+	case bool:
+		return "bool"
+	case []any, []string:
+		return "list"
+	case map[string]any:
+		return "table"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// containsConfigValue reports whether values holds value
+func containsConfigValue(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatConfigValue formats a setting's value as gsplug config prints it, and as
+// SetPluginConfig parses it
+func FormatConfigValue(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []string:
+		return strings.Join(value, ",")
+	}
+	return formatConfigValue(value)
+}
+
+// formatConfigValue formats a value for error messages
+func formatConfigValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, formatConfigValue(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []string:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, strconv.Quote(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,290 @@
+package gsplug
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFieldCoerce(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   ConfigField
+		value   any
+		want    any
+		wantErr string
+	}{
+		{name: "string", field: ConfigField{}, value: "x", want: "x"},
+		{name: "string from int", field: ConfigField{}, value: int64(1), wantErr: "expected string, found int"},
+		{name: "int", field: ConfigField{Type: ConfigTypeInt}, value: int64(3), want: int64(3)},
+		{name: "int from Go int", field: ConfigField{Type: ConfigTypeInt}, value: 3, want: int64(3)},
+		{name: "int from JSON float", field: ConfigField{Type: ConfigTypeInt}, value: float64(3), want: int64(3)},
+		{name: "int from negative JSON float", field: ConfigField{Type: ConfigTypeInt}, value: float64(-40), want: int64(-40)},
+		{name: "int from fractional float", field: ConfigField{Type: ConfigTypeInt}, value: 3.5, wantErr: "expected int, found float"},
+		{name: "int from overflowing float", field: ConfigField{Type: ConfigTypeInt}, value: 1e19, wantErr: "expected int, found float"},
+		{name: "float from int", field: ConfigField{Type: ConfigTypeFloat}, value: int64(2), want: float64(2)},
+		{name: "bool from string", field: ConfigField{Type: ConfigTypeBool}, value: "true", wantErr: "expected bool, found string"},
+		{name: "duration from string", field: ConfigField{Type: ConfigTypeDuration}, value: "90s", want: 90 * time.Second},
+		{name: "invalid duration", field: ConfigField{Type: ConfigTypeDuration}, value: "soon", wantErr: `"soon" is not a duration`},
+		{name: "list from TOML array", field: ConfigField{Type: ConfigTypeList}, value: []any{"a", "b"}, want: []string{"a", "b"}},
+		{name: "list with a non-string item", field: ConfigField{Type: ConfigTypeList}, value: []any{"a", int64(1)}, wantErr: "found an item of type int"},
+		{name: "list from string", field: ConfigField{Type: ConfigTypeList}, value: "a", wantErr: "expected list, found string"},
+		{name: "unknown type", field: ConfigField{Type: "map"}, value: "x", wantErr: `unknown type "map"`},
+		{
+			name:  "string enum",
+			field: ConfigField{Enum: []any{"red", "green"}},
+			value: "green",
+			want:  "green",
+		},
+		{
+			name:    "string outside enum",
+			field:   ConfigField{Enum: []any{"red", "green"}},
+			value:   "blue",
+			wantErr: `"blue" is not one of ["red", "green"]`,
+		},
+		{
+			name:  "int enum from JSON floats",
+			field: ConfigField{Type: ConfigTypeInt, Enum: []any{float64(1), float64(2)}},
+			value: float64(2),
+			want:  int64(2),
+		},
+		{
+			name:  "list enum",
+			field: ConfigField{Type: ConfigTypeList, Enum: []any{"read", "write", "admin"}},
+			value: []any{"read", "admin"},
+			want:  []string{"read", "admin"},
+		},
+		{
+			name:    "list item outside enum",
+			field:   ConfigField{Type: ConfigTypeList, Enum: []any{"read", "write"}},
+			value:   []any{"read", "delete"},
+			wantErr: `"delete" is not one of ["read", "write"]`,
+		},
+		{
+			name:    "enum of the wrong type",
+			field:   ConfigField{Type: ConfigTypeInt, Enum: []any{"one"}},
+			value:   int64(1),
+			wantErr: `enum value "one": expected int, found string`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.field.coerce(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("coerce(%#v) error = %v, want it to contain %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("coerce(%#v): %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coerce(%#v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFieldParse(t *testing.T) {
+	tests := []struct {
+		typ     string
+		text    string
+		want    any
+		wantErr bool
+	}{
+		{typ: ConfigTypeString, text: " a,b ", want: " a,b "},
+		{typ: ConfigTypeInt, text: "-12", want: int64(-12)},
+		{typ: ConfigTypeInt, text: "1.5", wantErr: true},
+		{typ: ConfigTypeFloat, text: "1.5", want: 1.5},
+		{typ: ConfigTypeBool, text: "false", want: false},
+		{typ: ConfigTypeBool, text: "yes", wantErr: true},
+		{typ: ConfigTypeDuration, text: "5m", want: 5 * time.Minute},
+		{typ: ConfigTypeList, text: "a, b,,c ", want: []string{"a", "b", "c"}},
+		{typ: ConfigTypeList, text: "", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ+"/"+tt.text, func(t *testing.T) {
+			got, err := ConfigField{Type: tt.typ}.parse(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parse(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse(%q) = %#v, want %#v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginConfigDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]any
+		target  any
+		want    any
+		wantErr string
+	}{
+		{
+			name:   "matches field names ignoring case and underscores",
+			values: map[string]any{"api_url": "https://example.com", "retries": int64(3)},
+			target: &struct {
+				APIURL  string
+				Retries int
+			}{},
+			want: &struct {
+				APIURL  string
+				Retries int
+			}{APIURL: "https://example.com", Retries: 3},
+		},
+		{
+			name:   "config tag wins over a matching name",
+			values: map[string]any{"api_url": "https://example.com"},
+			target: &struct {
+				APIURL   string
+				Endpoint string `config:"api_url"`
+			}{},
+			want: &struct {
+				APIURL   string
+				Endpoint string `config:"api_url"`
+			}{Endpoint: "https://example.com"},
+		},
+		{
+			name:   "toml tag is used without a config tag",
+			values: map[string]any{"api_url": "https://example.com"},
+			target: &struct {
+				Endpoint string `toml:"api_url,omitempty"`
+			}{},
+			want: &struct {
+				Endpoint string `toml:"api_url,omitempty"`
+			}{Endpoint: "https://example.com"},
+		},
+		{
+			name:   "tagged fields do not match by name",
+			values: map[string]any{"api_url": "https://example.com"},
+			target: &struct {
+				APIURL string `config:"endpoint"`
+			}{},
+			want: &struct {
+				APIURL string `config:"endpoint"`
+			}{},
+		},
+		{
+			name:   "skips fields tagged -",
+			values: map[string]any{"api_url": "https://example.com"},
+			target: &struct {
+				APIURL string `config:"-"`
+			}{},
+			want: &struct {
+				APIURL string `config:"-"`
+			}{},
+		},
+		{
+			name:   "converts durations and ints",
+			values: map[string]any{"timeout": 2 * time.Second, "interval": time.Minute, "ratio": int64(2)},
+			target: &struct {
+				Timeout  string
+				Interval time.Duration
+				Ratio    float32
+			}{},
+			want: &struct {
+				Timeout  string
+				Interval time.Duration
+				Ratio    float32
+			}{Timeout: "2s", Interval: time.Minute, Ratio: 2},
+		},
+		{
+			name:   "rejects ints that overflow the field",
+			values: map[string]any{"level": int64(300)},
+			target: &struct {
+				Level int8
+			}{},
+			wantErr: "setting level: 300 overflows int8",
+		},
+		{
+			name:   "fills maps",
+			values: map[string]any{"level": int64(3)},
+			target: &map[string]any{},
+			want:   &map[string]any{"level": int64(3)},
+		},
+		{
+			name:    "rejects non-pointers",
+			values:  map[string]any{},
+			target:  struct{}{},
+			wantErr: "want a non-nil pointer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &PluginConfig{Plugin: "test", values: tt.values}
+
+			err := conf.Decode(tt.target)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decode error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(tt.target, tt.want) {
+				t.Errorf("Decode = %+v, want %+v", tt.target, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPluginConfig(t *testing.T) {
+	manifest := &PluginManifest{Config: []ConfigField{
+		{Name: "api_url", Default: "https://default.example.com"},
+		{Name: "retries", Type: ConfigTypeInt, Default: int64(1)},
+		{Name: "scopes", Type: ConfigTypeList, Enum: []any{"read", "write"}},
+		{Name: "token", Required: true, Secret: true},
+	}}
+	manifest.Metadata.Name = "my-plugin"
+
+	cfg := &Config{Home: t.TempDir()}
+	path := cfg.PluginConfigPath("my-plugin")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("retries = 5\nscopes = [\"read\"]\nunknown = true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITSPACE_PLUGIN_MY_PLUGIN_SCOPES", "read, write")
+
+	conf, err := cfg.LoadPluginConfig(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"api_url": "https://default.example.com",
+		"retries": int64(5),
+		"scopes":  []string{"read", "write"},
+	}
+	if got := conf.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Values() = %#v, want %#v", got, want)
+	}
+	var sources []string
+	for _, setting := range conf.Settings() {
+		sources = append(sources, setting.Field.Name+"="+setting.Source)
+	}
+	if got, want := strings.Join(sources, " "), "api_url=default retries=file scopes=env token="; got != want {
+		t.Errorf("sources = %q, want %q", got, want)
+	}
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "missing required settings: token") {
+		t.Errorf("Validate() = %v, want the missing token reported", err)
+	}
+
+	t.Setenv("GITSPACE_PLUGIN_MY_PLUGIN_SCOPES", "admin")
+	if _, err := cfg.LoadPluginConfig(manifest); err == nil || !strings.Contains(err.Error(), "GITSPACE_PLUGIN_MY_PLUGIN_SCOPES") {
+		t.Errorf("LoadPluginConfig with an invalid environment value = %v, want an error naming the variable", err)
+	}
+}
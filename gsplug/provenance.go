@@ -0,0 +1,159 @@
+package gsplug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the version of gsplug, recorded in build provenance
+const Version = "1.0.0"
+
+// ProvenanceExt is appended to an artifact's file name to name its provenance file
+const ProvenanceExt = ".provenance.json"
+
+// SourceDateEpochEnv fixes the timestamps of reproducible builds, see
+// https://reproducible-builds.org/specs/source-date-epoch/
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// provenanceFormatVersion is the version of the provenance files written by builds
+const provenanceFormatVersion = 1
+
+// Provenance describes how an artifact was built. Every build writes one next to each
+// artifact. In reproducible mode it holds nothing that varies between builds of the same
+// commit, so rebuilding the recorded commit must give an artifact with the same SHA256.
+type Provenance struct {
+	FormatVersion int `json:"format_version"`
+	// Artifact is the file name of the artifact, and Mode its build mode
+	Artifact      string `json:"artifact"`
+	Mode          string `json:"mode"`
+	SHA256        string `json:"sha256"`
+	PluginName    string `json:"plugin_name"`
+	PluginVersion string `json:"plugin_version"`
+	// Source is the Git commit built, when the plugin directory is in a repository
+	Source *ProvenanceSource `json:"source,omitempty"`
+	// InputsSHA256 is the fingerprint of every build input, as in the build record
+	InputsSHA256 string `json:"inputs_sha256"`
+	Toolchain    string `json:"toolchain"`
+	Platform     string `json:"platform"`
+	Reproducible bool   `json:"reproducible"`
+	// SourceDateEpoch is the timestamp of a reproducible build
+	SourceDateEpoch int64 `json:"source_date_epoch,omitempty"`
+	// BuildArgs are the arguments to the go command, with the output path relative to
+	// the plugin directory
+	BuildArgs []string `json:"build_args"`
+	// Env holds the variables set for the go command on top of the runner's environment
+	Env []string `json:"env"`
+	// CanonicalDeps is the snapshot of canonical-deps.json the build was pinned to, and
+	// Requirements the module versions required by the plugin's go.mod
+	CanonicalDeps map[string]string `json:"canonical_deps"`
+	Requirements  map[string]string `json:"requirements"`
+	GsplugVersion string            `json:"gsplug_version"`
+	Runner        string            `json:"runner"`
+	BuiltAt       time.Time         `json:"built_at"`
+}
+
+// ProvenanceSource identifies the commit an artifact was built from
+type ProvenanceSource struct {
+	Commit string `json:"commit"`
+	// Dirty is set if the working tree had uncommitted changes
+	Dirty bool `json:"dirty"`
+	// Repository is the URL of the origin remote, if there is one
+	Repository string `json:"repository,omitempty"`
+}
+
+// ReadProvenance reads the provenance file of the artifact at path
+func ReadProvenance(path string) (*Provenance, error) {
+	data, err := os.ReadFile(path + ProvenanceExt)
+	if err != nil {
+		return nil, err
+	}
+
+	var provenance Provenance
+	if err := json.Unmarshal(data, &provenance); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path+ProvenanceExt, err)
+	}
+
+	return &provenance, nil
+}
+
+// writeProvenance writes the provenance file of the artifact at path
+func writeProvenance(path string, provenance *Provenance) error {
+	data, err := json.MarshalIndent(provenance, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+ProvenanceExt, append(data, '\n'), 0644)
+}
+
+// sourceDate returns the timestamp of a reproducible build of the plugin in dir:
+// $SOURCE_DATE_EPOCH, else the time of the commit being built, else the Unix epoch
+func sourceDate(ctx context.Context, dir string) (time.Time, error) {
+	if value := os.Getenv(SourceDateEpochEnv); value != "" {
+		epoch, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s %q: %w", SourceDateEpochEnv, value, err)
+		}
+		return time.Unix(epoch, 0).UTC(), nil
+	}
+
+	if out, err := gitOutput(ctx, dir, "log", "-1", "--format=%ct"); err == nil {
+		if epoch, err := strconv.ParseInt(out, 10, 64); err == nil {
+			return time.Unix(epoch, 0).UTC(), nil
+		}
+	}
+
+	return time.Unix(0, 0).UTC(), nil
+}
+
+// gitSource returns the commit checked out in dir, or nil if dir is not in a Git repository
+func gitSource(ctx context.Context, dir string) *ProvenanceSource {
+	commit, err := gitOutput(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil
+	}
+	source := &ProvenanceSource{Commit: commit}
+
+	if status, err := gitOutput(ctx, dir, "status", "--porcelain", "--", "."); err == nil {
+		source.Dirty = status != ""
+	}
+	if remote, err := gitOutput(ctx, dir, "remote", "get-url", "origin"); err == nil {
+		source.Repository = remote
+	}
+
+	return source
+}
+
+// gitOutput runs git in dir and returns its trimmed standard output
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// relativeOutputArgs returns args with the output path following -o made relative to
+// pluginDir, so provenance and fingerprints do not depend on where the plugin lives
+func relativeOutputArgs(args []string, pluginDir string) []string {
+	relative := append([]string(nil), args...)
+	for i := 0; i+1 < len(relative); i++ {
+		if relative[i] == "-o" {
+			if rel, err := filepath.Rel(pluginDir, relative[i+1]); err == nil {
+				relative[i+1] = filepath.ToSlash(rel)
+			}
+		}
+	}
+	return relative
+}
@@ -0,0 +1,137 @@
+package gsplug
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RegistryFileName is the file in the plugins directory recording installed plugins
+const RegistryFileName = "registry.json"
+
+// registryFormatVersion is the version of the registry file written by gsplug
+const registryFormatVersion = 1
+
+// Sources Install accepts, as recorded in InstalledPlugin.Kind
+const (
+	// InstallFromPackage installs a package written by Package
+	InstallFromPackage = "package"
+	// InstallFromDir copies and builds a plugin source directory
+	InstallFromDir = "dir"
+	// InstallFromGit clones and builds a plugin from a local Git repository
+	InstallFromGit = "git"
+)
+
+// Registry is the registry.json file of the plugins directory
+type Registry struct {
+	FormatVersion int `json:"format_version"`
+	// Plugins holds the installed plugins by name
+	Plugins map[string]*InstalledPlugin `json:"plugins"`
+}
+
+// InstalledPlugin is the registry entry of a plugin installed by Install
+type InstalledPlugin struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Kind is InstallFromPackage, InstallFromDir or InstallFromGit
+	Kind string `json:"kind"`
+	// Source is the archive, directory or repository the plugin was installed from
+	Source string `json:"source"`
+	// Commit is the commit a plugin installed from Git was built from
+	Commit string `json:"commit,omitempty"`
+	// SHA256 is the checksum of the archive a package was installed from
+	SHA256 string `json:"sha256,omitempty"`
+	// SignedBy lists the IDs of the trusted keys that signed a package's artifacts
+	SignedBy []string `json:"signed_by,omitempty"`
+	// Dir is the plugin's directory, relative to the plugins directory
+	Dir string `json:"dir"`
+	// Artifacts lists the prebuilt artifacts of a package, relative to Dir. Plugins
+	// installed from source are built in place, so their artifacts come from the manifest.
+	Artifacts []PackageArtifact `json:"artifacts,omitempty"`
+	// Platform and Toolchain are the GOOS/GOARCH and Go version a package was built for
+	Platform  string `json:"platform,omitempty"`
+	Toolchain string `json:"toolchain,omitempty"`
+	// BuiltAt is when the artifacts of a package were built
+	BuiltAt     time.Time `json:"built_at"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// ReadRegistry reads the registry of installed plugins. A missing registry is empty.
+func (c *Config) ReadRegistry() (*Registry, error) {
+	registry := &Registry{FormatVersion: registryFormatVersion, Plugins: make(map[string]*InstalledPlugin)}
+
+	data, err := os.ReadFile(c.RegistryPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return registry, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", c.RegistryPath(), err)
+	}
+	if registry.FormatVersion > registryFormatVersion {
+		return nil, fmt.Errorf("%s has format version %d, but this gsplug supports up to %d", c.RegistryPath(), registry.FormatVersion, registryFormatVersion)
+	}
+	if registry.Plugins == nil {
+		registry.Plugins = make(map[string]*InstalledPlugin)
+	}
+
+	return registry, nil
+}
+
+// updateRegistry applies update to the registry and writes it back atomically
+func (c *Config) updateRegistry(update func(*Registry) error) error {
+	registry, err := c.ReadRegistry()
+	if err != nil {
+		return err
+	}
+	if err := update(registry); err != nil {
+		return err
+	}
+	registry.FormatVersion = registryFormatVersion
+
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.RegistryPath(), append(data, '\n'), 0644)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it over path,
+// so readers see either the old or the new content, never a partial write
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Make sure the data is on disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,726 @@
+package gsplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RPCProtocolVersion is the version of the stdio JSON-RPC protocol spoken between
+	// Gitspace and out-of-process plugins. It is bumped on every incompatible change.
+	RPCProtocolVersion = 1
+
+	// RPCProtocolEnv is set by the host when it starts a plugin process. Its value is
+	// the protocol version the host speaks.
+	RPCProtocolEnv = "GITSPACE_PLUGIN_PROTOCOL"
+
+	rpcServiceName = "Plugin"
+
+	// rpcShutdownGrace is how long Close waits for a plugin process to exit before killing it
+	rpcShutdownGrace = 5 * time.Second
+)
+
+// Capabilities advertised by an out-of-process plugin during the handshake
+const (
+	CapabilityShutdown  = "shutdown"
+	CapabilityStop      = "stop"
+	CapabilityConfigure = "configure"
+	CapabilityMenu      = "menu"
+	// CapabilityContext is advertised by plugin processes that accept a PluginContext
+	CapabilityContext = "context"
+)
+
+// HandshakeArgs is sent by the host when it connects to a plugin process
+type HandshakeArgs struct {
+	ProtocolVersion  int
+	PluginAPIVersion string
+}
+
+// HandshakeReply describes the plugin served by a plugin process
+type HandshakeReply struct {
+	ProtocolVersion  int
+	PluginAPIVersion string
+	Name             string
+	Version          string
+	Description      string
+	Capabilities     []string
+}
+
+// ConfigureArgs carries the settings passed to Configurable.Configure. Durations are sent
+// as strings.
+type ConfigureArgs struct {
+	Config map[string]interface{}
+}
+
+// MenuOptionReply carries the result of GetMenuOption
+type MenuOptionReply struct {
+	Option *Option
+}
+
+// PluginContextArgs is a PluginContext as sent over RPC. The plugin process logs to its
+// standard error at LogLevel; host services are not available to it.
+type PluginContextArgs struct {
+	Manifest    *PluginManifest
+	Dir         string
+	DataDir     string
+	CacheDir    string
+	VersionInfo *VersionInfo
+	LogLevel    slog.Level
+	// Config holds the plugin's settings, with durations as strings
+	Config map[string]any
+	// CallID identifies the call to the plugin process, so that the host can cancel it
+	CallID uint64
+}
+
+// cancelableArgs are the arguments of calls that the host can cancel with Cancel
+type cancelableArgs interface {
+	callID() uint64
+}
+
+func (a PluginContextArgs) callID() uint64 { return a.CallID }
+func (a RunMenuItemArgs) callID() uint64   { return a.CallID }
+
+// CancelArgs names the call whose context to cancel
+type CancelArgs struct {
+	CallID uint64
+}
+
+// StopArgs carries the time the plugin has to finish Stopper.Stop
+type StopArgs struct {
+	Timeout time.Duration
+}
+
+// MenuItemInfo is a MenuItem as sent over RPC. Its predicates are evaluated by the plugin
+// process when the host asks for the menu.
+type MenuItemInfo struct {
+	Key       string
+	Title     string
+	Icon      string
+	Hotkey    string
+	Weight    int
+	Enabled   bool
+	Visible   bool
+	HasAction bool
+	Items     []MenuItemInfo
+}
+
+// MenuItemsReply carries the result of MenuContributor.MenuItems
+type MenuItemsReply struct {
+	Items []MenuItemInfo
+}
+
+// RunMenuItemArgs names the item whose action to run by the keys leading to it
+type RunMenuItemArgs struct {
+	Path []string
+	// CallID identifies the call to the plugin process, so that the host can cancel it
+	CallID uint64
+}
+
+// Empty is used for RPC calls without arguments or results
+type Empty struct{}
+
+// IsPluginProcess reports whether the current process was started by Gitspace as an out-of-process plugin
+func IsPluginProcess() bool {
+	return os.Getenv(RPCProtocolEnv) != ""
+}
+
+// ServePlugin serves p over stdin/stdout until the host disconnects.
+// Anything the plugin writes to os.Stdout afterwards is redirected to stderr so
+// that it cannot corrupt the protocol stream.
+func ServePlugin(p Plugin) error {
+	conn := &stdioConn{
+		Reader:  os.Stdin,
+		Writer:  os.Stdout,
+		closers: []io.Closer{os.Stdin, os.Stdout},
+	}
+	os.Stdout = os.Stderr
+
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, &pluginServer{impl: p, cancels: make(map[uint64]context.CancelFunc)}); err != nil {
+		return fmt.Errorf("failed to register plugin service: %w", err)
+	}
+
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
+
+// pluginServer exposes a Plugin implementation over net/rpc. A panic in the plugin is
+// returned to the host as an error rather than crashing the process.
+type pluginServer struct {
+	impl Plugin
+
+	mu      sync.Mutex
+	cancels map[uint64]context.CancelFunc
+}
+
+func (s *pluginServer) Handshake(args HandshakeArgs, reply *HandshakeReply) error {
+	if args.ProtocolVersion != RPCProtocolVersion {
+		return fmt.Errorf("unsupported plugin protocol version %d, plugin speaks %d", args.ProtocolVersion, RPCProtocolVersion)
+	}
+
+	reply.ProtocolVersion = RPCProtocolVersion
+	reply.PluginAPIVersion = PluginAPIVersion
+	reply.Name = s.impl.Name()
+	reply.Version = s.impl.Version()
+	reply.Description = s.impl.Description()
+	if _, ok := s.impl.(Shutdowner); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityShutdown)
+	}
+	if _, ok := s.impl.(Stopper); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityStop)
+	}
+	if _, ok := s.impl.(Configurable); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityConfigure)
+	}
+	if _, ok := s.impl.(MenuContributor); ok {
+		reply.Capabilities = append(reply.Capabilities, CapabilityMenu)
+	}
+	// Plugins that are not ContextInitializers or ContextRunners fall back to Init and Run
+	reply.Capabilities = append(reply.Capabilities, CapabilityContext)
+	return nil
+}
+
+// guard calls fn, which calls into the plugin, returning a panic as a *PanicError
+func (s *pluginServer) guard(fn func() error) error {
+	return guardPanic(s.impl.Name(), fn)
+}
+
+func (s *pluginServer) Init(args Empty, reply *Empty) error {
+	return s.guard(s.impl.Init)
+}
+
+func (s *pluginServer) Run(args Empty, reply *Empty) error {
+	return s.guard(s.impl.Run)
+}
+
+func (s *pluginServer) InitContext(args PluginContextArgs, reply *Empty) error {
+	return s.withContext(args, func(pc *PluginContext) error { return InitPlugin(s.impl, pc) })
+}
+
+func (s *pluginServer) RunContext(args PluginContextArgs, reply *Empty) error {
+	return s.withContext(args, func(pc *PluginContext) error { return RunPlugin(s.impl, pc) })
+}
+
+// Cancel cancels the context of a running InitContext, RunContext or RunMenuItem call
+func (s *pluginServer) Cancel(args CancelArgs, reply *Empty) error {
+	s.mu.Lock()
+	cancel := s.cancels[args.CallID]
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	return nil
+}
+
+// withContext calls fn with the PluginContext sent by the host, which Cancel can cancel
+func (s *pluginServer) withContext(args PluginContextArgs, fn func(pc *PluginContext) error) error {
+	pc, err := s.pluginContext(args)
+	if err != nil {
+		return err
+	}
+	return s.cancelable(args.CallID, func(ctx context.Context) error {
+		return fn(pc.WithContext(ctx))
+	})
+}
+
+// cancelable calls fn with a context that Cancel can cancel by callID
+func (s *pluginServer) cancelable(callID uint64, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancels[callID] = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.cancels, callID)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	return s.guard(func() error { return fn(ctx) })
+}
+
+// pluginContext rebuilds the PluginContext sent by the host
+func (s *pluginServer) pluginContext(args PluginContextArgs) (*PluginContext, error) {
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: args.LogLevel})
+	logger := slog.New(handler)
+	if args.Manifest != nil {
+		logger = logger.With("plugin", args.Manifest.Metadata.Name)
+	}
+	pc := &PluginContext{
+		Context:     context.Background(),
+		Manifest:    args.Manifest,
+		Dir:         args.Dir,
+		DataDir:     args.DataDir,
+		CacheDir:    args.CacheDir,
+		Logger:      logger,
+		VersionInfo: args.VersionInfo,
+	}
+	if args.Manifest != nil {
+		config, err := newPluginConfig(args.Manifest, args.Config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin configuration from host: %w", err)
+		}
+		pc.Config = config
+	}
+	return pc, nil
+}
+
+func (s *pluginServer) GetMenuOption(args Empty, reply *MenuOptionReply) error {
+	return s.guard(func() error {
+		reply.Option = s.impl.GetMenuOption()
+		return nil
+	})
+}
+
+func (s *pluginServer) MenuItems(args Empty, reply *MenuItemsReply) error {
+	contributor, ok := s.impl.(MenuContributor)
+	if !ok {
+		return nil
+	}
+	return s.guard(func() error {
+		reply.Items = menuItemInfos(s.impl.Name(), contributor.MenuItems())
+		return nil
+	})
+}
+
+func (s *pluginServer) RunMenuItem(args RunMenuItemArgs, reply *Empty) error {
+	contributor, ok := s.impl.(MenuContributor)
+	if !ok {
+		return errors.New("plugin does not contribute menu items")
+	}
+	return s.cancelable(args.CallID, func(ctx context.Context) error {
+		menu := &Menu{Items: contributor.MenuItems()}
+		item := menu.Find(args.Path...)
+		if item == nil || len(args.Path) == 0 {
+			return fmt.Errorf("no menu item %s", strings.Join(args.Path, "/"))
+		}
+		if item.Action == nil {
+			return fmt.Errorf("menu item %s has no action", strings.Join(args.Path, "/"))
+		}
+		return item.Action(ctx)
+	})
+}
+
+func (s *pluginServer) Configure(args ConfigureArgs, reply *Empty) error {
+	configurable, ok := s.impl.(Configurable)
+	if !ok {
+		return errors.New("plugin does not accept configuration")
+	}
+	return s.guard(func() error { return configurable.Configure(args.Config) })
+}
+
+func (s *pluginServer) Shutdown(args Empty, reply *Empty) error {
+	if shutdowner, ok := s.impl.(Shutdowner); ok {
+		return s.guard(shutdowner.Shutdown)
+	}
+	return nil
+}
+
+func (s *pluginServer) Stop(args StopArgs, reply *Empty) error {
+	ctx, cancel := context.WithTimeout(context.Background(), args.Timeout)
+	defer cancel()
+	return s.guard(func() error { return StopPlugin(ctx, s.impl) })
+}
+
+// RPCPlugin is the host-side handle to a plugin running in a separate process.
+// It implements Plugin, Shutdowner, Stopper, Configurable, MenuContributor,
+// ContextInitializer and ContextRunner by forwarding calls to the process.
+type RPCPlugin struct {
+	path   string
+	cmd    *exec.Cmd
+	client *rpc.Client
+	info   HandshakeReply
+
+	nextCallID atomic.Uint64
+	stopped    atomic.Bool
+
+	exited  chan struct{}
+	waitErr error
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// StartRPCPlugin starts the plugin executable at path and performs the protocol handshake
+func StartRPCPlugin(path string, args ...string) (*RPCPlugin, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), RPCProtocolEnv+"="+strconv.Itoa(RPCProtocolVersion))
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = os.Stderr
+
+	startErr := cmd.Start()
+	// The child holds its own copies of these ends
+	stdinR.Close()
+	stdoutW.Close()
+	if startErr != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, startErr)
+	}
+
+	p := &RPCPlugin{
+		path:   path,
+		cmd:    cmd,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+
+	conn := &stdioConn{
+		Reader:  stdoutR,
+		Writer:  stdinW,
+		closers: []io.Closer{stdinW, stdoutR},
+	}
+	p.client = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn))
+
+	handshake := HandshakeArgs{ProtocolVersion: RPCProtocolVersion, PluginAPIVersion: PluginAPIVersion}
+	if err := p.call("Handshake", handshake, &p.info); err != nil {
+		p.kill()
+		return nil, fmt.Errorf("plugin handshake failed: %w", err)
+	}
+	if p.info.ProtocolVersion != RPCProtocolVersion {
+		p.kill()
+		return nil, fmt.Errorf("plugin %s speaks protocol version %d, host speaks %d", path, p.info.ProtocolVersion, RPCProtocolVersion)
+	}
+	// Out-of-process plugins cannot be adapted in the host, so any major mismatch is refused
+	negotiation, err := NegotiateAPIVersion(PluginAPIVersion, p.info.PluginAPIVersion)
+	if err == nil && negotiation.Adapted {
+		err = &APIVersionError{Host: PluginAPIVersion, Plugin: p.info.PluginAPIVersion, Reason: "out-of-process plugins must match the host's API major version"}
+	}
+	if err != nil {
+		p.kill()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *RPCPlugin) Init() error {
+	return p.call("Init", Empty{}, &Empty{})
+}
+
+// InitContext initializes the plugin with pc, or with Init if the plugin process predates
+// PluginContext
+func (p *RPCPlugin) InitContext(pc *PluginContext) error {
+	if !p.HasCapability(CapabilityContext) {
+		return p.Init()
+	}
+	return p.callContext(pc, "InitContext", pluginContextArgs(pc, p.nextCallID.Add(1)), &Empty{})
+}
+
+// RunContext runs the plugin with pc, or with Run if the plugin process predates
+// PluginContext
+func (p *RPCPlugin) RunContext(pc *PluginContext) error {
+	if !p.HasCapability(CapabilityContext) {
+		return p.Run()
+	}
+	return p.callContext(pc, "RunContext", pluginContextArgs(pc, p.nextCallID.Add(1)), &Empty{})
+}
+
+// pluginContextArgs converts pc for sending over RPC as the call with the given ID
+func pluginContextArgs(pc *PluginContext, callID uint64) PluginContextArgs {
+	args := PluginContextArgs{
+		CallID:      callID,
+		Manifest:    pc.Manifest,
+		Dir:         pc.Dir,
+		DataDir:     pc.DataDir,
+		CacheDir:    pc.CacheDir,
+		VersionInfo: pc.VersionInfo,
+		LogLevel:    slog.LevelInfo,
+	}
+	if pc.Logger != nil {
+		args.LogLevel = loggerLevel(pc.Logger)
+	}
+	if pc.Config != nil {
+		args.Config = rpcConfigValues(pc.Config.Values())
+	}
+	return args
+}
+
+// rpcConfigValues returns a copy of plugin settings with durations as strings, which JSON
+// would otherwise turn into numbers of nanoseconds
+func rpcConfigValues(values map[string]any) map[string]any {
+	converted := make(map[string]any, len(values))
+	for name, value := range values {
+		if duration, ok := value.(time.Duration); ok {
+			value = duration.String()
+		}
+		converted[name] = value
+	}
+	return converted
+}
+
+func (p *RPCPlugin) Name() string {
+	return p.info.Name
+}
+
+func (p *RPCPlugin) Version() string {
+	return p.info.Version
+}
+
+func (p *RPCPlugin) Description() string {
+	return p.info.Description
+}
+
+func (p *RPCPlugin) Run() error {
+	return p.call("Run", Empty{}, &Empty{})
+}
+
+// GetMenuOption returns the plugin's menu option, or nil if the plugin process cannot be reached
+func (p *RPCPlugin) GetMenuOption() *Option {
+	var reply MenuOptionReply
+	if err := p.call("GetMenuOption", Empty{}, &reply); err != nil {
+		return nil
+	}
+	return reply.Option
+}
+
+func (p *RPCPlugin) Configure(config map[string]interface{}) error {
+	if !p.HasCapability(CapabilityConfigure) {
+		return fmt.Errorf("plugin %s does not accept configuration", p.info.Name)
+	}
+	return p.call("Configure", ConfigureArgs{Config: rpcConfigValues(config)}, &Empty{})
+}
+
+// MenuItems returns the plugin's menu items, whose actions run in the plugin process. It
+// returns nil if the plugin does not contribute menu items or cannot be reached.
+func (p *RPCPlugin) MenuItems() []*MenuItem {
+	if !p.HasCapability(CapabilityMenu) {
+		return nil
+	}
+	var reply MenuItemsReply
+	if err := p.call("MenuItems", Empty{}, &reply); err != nil {
+		return nil
+	}
+	return p.menuItems(reply.Items, nil)
+}
+
+// menuItems turns the menu items sent by the plugin process back into MenuItems
+func (p *RPCPlugin) menuItems(infos []MenuItemInfo, path []string) []*MenuItem {
+	items := make([]*MenuItem, 0, len(infos))
+	for _, info := range infos {
+		itemPath := append(append([]string(nil), path...), info.Key)
+		enabled, visible := info.Enabled, info.Visible
+		item := &MenuItem{
+			Key:     info.Key,
+			Title:   info.Title,
+			Icon:    info.Icon,
+			Hotkey:  info.Hotkey,
+			Weight:  info.Weight,
+			Items:   p.menuItems(info.Items, itemPath),
+			Enabled: func() bool { return enabled },
+			Visible: func() bool { return visible },
+		}
+		if info.HasAction {
+			item.Action = func(ctx context.Context) error {
+				args := RunMenuItemArgs{Path: itemPath, CallID: p.nextCallID.Add(1)}
+				return p.callContext(ctx, "RunMenuItem", args, &Empty{})
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// menuItemInfos converts the menu items of plugin for sending over RPC, evaluating their
+// predicates. Predicates that panic are logged and report false, as in Manager.Menu.
+func menuItemInfos(plugin string, items []*MenuItem) []MenuItemInfo {
+	var infos []MenuItemInfo
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		guarded := MenuItem{
+			Enabled: guardMenuPredicate(item.Enabled, plugin, item.Key, "Enabled", slog.Default()),
+			Visible: guardMenuPredicate(item.Visible, plugin, item.Key, "Visible", slog.Default()),
+		}
+		infos = append(infos, MenuItemInfo{
+			Key:       item.Key,
+			Title:     item.Title,
+			Icon:      item.Icon,
+			Hotkey:    item.Hotkey,
+			Weight:    item.Weight,
+			Enabled:   guarded.IsEnabled(),
+			Visible:   guarded.IsVisible(),
+			HasAction: item.Action != nil,
+			Items:     menuItemInfos(plugin, item.Items),
+		})
+	}
+	return infos
+}
+
+func (p *RPCPlugin) Shutdown() error {
+	if !p.HasCapability(CapabilityShutdown) {
+		return nil
+	}
+	return p.call("Shutdown", Empty{}, &Empty{})
+}
+
+// Stop asks the plugin to stop by ctx's deadline, with Stop or else Shutdown. The process
+// is killed if it has not answered by then. Close does not stop the plugin again.
+func (p *RPCPlugin) Stop(ctx context.Context) error {
+	p.stopped.Store(true)
+	if !p.HasCapability(CapabilityStop) {
+		return p.callContext(ctx, "Shutdown", Empty{}, &Empty{})
+	}
+
+	timeout := rpcShutdownGrace
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	return p.callContext(ctx, "Stop", StopArgs{Timeout: timeout}, &Empty{})
+}
+
+// HasCapability reports whether the plugin advertised the given capability during the handshake
+func (p *RPCPlugin) HasCapability(capability string) bool {
+	for _, c := range p.info.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Close shuts the plugin down, closes the connection and waits for the process to exit
+func (p *RPCPlugin) Close() error {
+	p.closeOnce.Do(func() {
+		var shutdownErr error
+		if !p.hasExited() && !p.stopped.Load() {
+			ctx, cancel := context.WithTimeout(context.Background(), rpcShutdownGrace)
+			shutdownErr = p.Stop(ctx)
+			cancel()
+		}
+		p.client.Close()
+
+		select {
+		case <-p.exited:
+		case <-time.After(rpcShutdownGrace):
+			p.kill()
+		}
+
+		if shutdownErr != nil {
+			p.closeErr = fmt.Errorf("plugin shutdown failed: %w", shutdownErr)
+		}
+	})
+	return p.closeErr
+}
+
+// call invokes a method on the plugin process, reporting a crashed process as such
+func (p *RPCPlugin) call(method string, args, reply interface{}) error {
+	return p.callContext(context.Background(), method, args, reply)
+}
+
+// callContext is call, giving up when ctx is done. Calls with a CallID are canceled in
+// the plugin process too, and the process is killed if it is still busy
+// rpcShutdownGrace later, so that a plugin ignoring cancellation cannot hang the host.
+// Other calls cannot be canceled, so the process is killed right away.
+func (p *RPCPlugin) callContext(ctx context.Context, method string, args, reply interface{}) error {
+	call := p.client.Go(rpcServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		grace := time.Duration(0)
+		if cancelable, ok := args.(cancelableArgs); ok {
+			p.client.Go(rpcServiceName+".Cancel", CancelArgs{CallID: cancelable.callID()}, &Empty{}, make(chan *rpc.Call, 1))
+			grace = rpcShutdownGrace
+		}
+		select {
+		case <-call.Done:
+		case <-p.exited:
+		case <-time.After(grace):
+			p.kill()
+			return fmt.Errorf("plugin process %s was killed after ignoring cancellation of %s: %w", p.path, method, context.Cause(ctx))
+		}
+		return fmt.Errorf("plugin %s canceled during %s: %w", p.info.Name, method, context.Cause(ctx))
+	case <-call.Done:
+	case <-p.exited:
+		// Give the client a moment to deliver a reply that raced with the exit
+		select {
+		case <-call.Done:
+		case <-time.After(100 * time.Millisecond):
+			return p.exitError(method)
+		}
+	}
+
+	if call.Error == nil {
+		return nil
+	}
+	if _, ok := call.Error.(rpc.ServerError); ok {
+		return call.Error
+	}
+
+	// The connection broke; if the process is gone, say so instead of reporting EOF
+	select {
+	case <-p.exited:
+		return p.exitError(method)
+	case <-time.After(time.Second):
+		return call.Error
+	}
+}
+
+func (p *RPCPlugin) hasExited() bool {
+	select {
+	case <-p.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *RPCPlugin) exitError(method string) error {
+	if p.waitErr != nil {
+		return fmt.Errorf("plugin process %s exited during %s: %w", p.path, method, p.waitErr)
+	}
+	return fmt.Errorf("plugin process %s exited during %s", p.path, method)
+}
+
+func (p *RPCPlugin) kill() {
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+	p.client.Close()
+	<-p.exited
+}
+
+// stdioConn joins a reader and a writer into the io.ReadWriteCloser net/rpc expects
+type stdioConn struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (c *stdioConn) Close() error {
+	var errs []error
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
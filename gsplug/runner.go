@@ -0,0 +1,234 @@
+package gsplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// GoCommand is an invocation of the go tool made while building a plugin
+type GoCommand struct {
+	Args []string
+	Dir  string
+	// Env holds the variables specific to the build (GOPROXY in offline mode, CGO_ENABLED
+	// and the manifest's [build] env). Runners add them to their own base environment.
+	Env []string
+	// Mounts are the host directories the command reads or writes: the plugin, modules
+	// it replaces with local directories and the artifacts' output directories
+	Mounts []string
+}
+
+// BuildRunner decides where and with which toolchain the go tool runs during a build
+type BuildRunner interface {
+	// Name identifies the runner and its toolchain; it is part of the build fingerprint
+	Name() string
+	// Command returns the command that runs cmd
+	Command(ctx context.Context, cmd GoCommand) (*exec.Cmd, error)
+}
+
+// LocalRunner runs the go tool found on PATH with the caller's environment
+type LocalRunner struct{}
+
+func (LocalRunner) Name() string {
+	return "local"
+}
+
+func (LocalRunner) Command(ctx context.Context, cmd GoCommand) (*exec.Cmd, error) {
+	c := exec.CommandContext(ctx, "go", cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Env = append(os.Environ(), cmd.Env...)
+	return c, nil
+}
+
+// GorootRunner runs the go tool of a pinned Go installation, never switching toolchains
+type GorootRunner struct {
+	GOROOT string
+}
+
+func (r GorootRunner) Name() string {
+	return "goroot:" + r.GOROOT
+}
+
+func (r GorootRunner) Command(ctx context.Context, cmd GoCommand) (*exec.Cmd, error) {
+	goBin := filepath.Join(r.GOROOT, "bin", "go")
+	if _, err := os.Stat(goBin); err != nil {
+		return nil, fmt.Errorf("no go tool in GOROOT %s: %w", r.GOROOT, err)
+	}
+
+	c := exec.CommandContext(ctx, goBin, cmd.Args...)
+	c.Dir = cmd.Dir
+	c.Env = append(os.Environ(),
+		"GOROOT="+r.GOROOT,
+		"GOTOOLCHAIN=local",
+		"PATH="+filepath.Join(r.GOROOT, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"),
+	)
+	c.Env = append(c.Env, cmd.Env...)
+	return c, nil
+}
+
+// Container paths of the Go caches mounted by ContainerRunner
+const (
+	containerModCache   = "/gsplug/gomodcache"
+	containerBuildCache = "/gsplug/gocache"
+)
+
+// ContainerRunner runs the go tool in a container, the way the release pipeline builds
+// gitspace-plugin. Host directories are mounted at the same paths inside the container so
+// that paths in go.mod replacements and artifact paths stay valid.
+type ContainerRunner struct {
+	// Engine is the container CLI, docker or podman
+	Engine string
+	// Image is the image providing the toolchain, such as golang:1.23.1
+	Image string
+	// ModCache and BuildCache are host directories mounted as the module and build caches
+	ModCache   string
+	BuildCache string
+}
+
+func (r ContainerRunner) Name() string {
+	return r.Engine + ":" + r.Image
+}
+
+func (r ContainerRunner) Command(ctx context.Context, cmd GoCommand) (*exec.Cmd, error) {
+	if _, err := exec.LookPath(r.Engine); err != nil {
+		return nil, fmt.Errorf("container engine %s not found: %w", r.Engine, err)
+	}
+
+	args := []string{"run", "--rm", "--workdir", cmd.Dir}
+
+	// Keep artifacts and caches owned by the calling user
+	if runtime.GOOS == "linux" {
+		if r.Engine == "podman" {
+			args = append(args, "--userns=keep-id")
+		} else {
+			args = append(args, "--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()))
+		}
+	}
+
+	for _, dir := range []string{r.ModCache, r.BuildCache} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	args = append(args,
+		"--volume", r.ModCache+":"+containerModCache,
+		"--volume", r.BuildCache+":"+containerBuildCache,
+	)
+	for _, mount := range cmd.Mounts {
+		args = append(args, "--volume", mount+":"+mount)
+	}
+
+	env := []string{
+		"HOME=/tmp",
+		"GOTOOLCHAIN=local",
+		"GOMODCACHE=" + containerModCache,
+		"GOCACHE=" + containerBuildCache,
+	}
+	// Forward the module settings of the caller; everything else comes from the image
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		switch key {
+		case "GOFLAGS", "GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE":
+			env = append(env, kv)
+		}
+	}
+	for _, kv := range append(env, cmd.Env...) {
+		args = append(args, "--env", kv)
+	}
+
+	args = append(args, r.Image, "go")
+	args = append(args, cmd.Args...)
+
+	c := exec.CommandContext(ctx, r.Engine, args...)
+	c.Dir = cmd.Dir
+	return c, nil
+}
+
+// matchesGoVersion reports whether the toolchain goVersion (such as go1.23.1) is version.
+// A version without a patch number (1.23) matches any release of that minor version, which
+// is enough for binaries; Go plugins need the exact release, see pluginGoVersion.
+func matchesGoVersion(goVersion, version string) bool {
+	goVersion = strings.TrimPrefix(goVersion, "go")
+	if strings.Count(version, ".") == 1 {
+		return goVersion == version || strings.HasPrefix(goVersion, version+".")
+	}
+	return goVersion == version
+}
+
+// Container engines tried, in order, when none is given to HermeticRunner
+var containerEngines = []string{"docker", "podman"}
+
+// HostGoVersion returns the Go version Gitspace is built with: the toolchain directive of
+// gitspace-go.mod if present, otherwise its go directive. The version has no "go" prefix.
+func (c *Config) HostGoVersion() (string, error) {
+	if err := c.EnsureGitspaceModFile(); err != nil {
+		return "", err
+	}
+
+	f, _, err := readModFile(c.GitspaceModPath())
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case f.Toolchain != nil:
+		return strings.TrimPrefix(f.Toolchain.Name, "go"), nil
+	case f.Go != nil:
+		return f.Go.Version, nil
+	default:
+		return "", fmt.Errorf("go version not found in %s", c.GitspaceModPath())
+	}
+}
+
+// HermeticRunner returns a runner whose toolchain matches the Gitspace host's Go version.
+// If goroot is set, that installation is used and must be the host's version; otherwise
+// builds run in a golang:<version> container using engine, or the first of docker and
+// podman that is installed.
+func (c *Config) HermeticRunner(engine, goroot string) (BuildRunner, error) {
+	version, err := c.HostGoVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the Gitspace Go version: %w", err)
+	}
+
+	if goroot != "" {
+		runner := GorootRunner{GOROOT: goroot}
+		actual, err := goVersion(context.Background(), runner, GoCommand{})
+		if err != nil {
+			return nil, err
+		}
+		if !matchesGoVersion(actual, version) {
+			return nil, fmt.Errorf("GOROOT %s provides %s, but Gitspace is built with go%s", goroot, actual, version)
+		}
+		return runner, nil
+	}
+
+	if engine == "" {
+		for _, candidate := range containerEngines {
+			if _, err := exec.LookPath(candidate); err == nil {
+				engine = candidate
+				break
+			}
+		}
+		if engine == "" {
+			return nil, errors.New("hermetic builds need docker or podman, or a pinned GOROOT")
+		}
+	}
+
+	modCache := filepath.Join(c.CacheDir(), "gomodcache")
+	if out, err := exec.Command("go", "env", "GOMODCACHE").Output(); err == nil && len(strings.TrimSpace(string(out))) > 0 {
+		// Share the host's module cache so that offline builds find their dependencies
+		modCache = strings.TrimSpace(string(out))
+	}
+
+	return ContainerRunner{
+		Engine:     engine,
+		Image:      "golang:" + version,
+		ModCache:   modCache,
+		BuildCache: filepath.Join(c.CacheDir(), "gocache"),
+	}, nil
+}
@@ -0,0 +1,348 @@
+package gsplug
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// PluginModulePath is the module path of this package's module, required by every new plugin
+const PluginModulePath = "github.com/ssotops/gitspace-plugin"
+
+// MinPluginModuleVersion is the first release of PluginModulePath with the APIs the
+// templates use, such as ServePlugin, IsPluginProcess, ParseManifest and PluginAPIVersion.
+// New plugins never require an older one, whatever Gitspace pins.
+const MinPluginModuleVersion = "v1.1.0"
+
+// DefaultTemplate is the template used by Scaffold when none is given
+const DefaultTemplate = "minimal"
+
+// scaffoldTemplate describes a project template under templates/
+type scaffoldTemplate struct {
+	Description string
+	// BuildMode is the [build] mode of the generated manifest
+	BuildMode string
+	// Requires lists the modules the generated go.mod requires
+	Requires []string
+}
+
+var scaffoldTemplates = map[string]scaffoldTemplate{
+	"minimal": {
+		Description: "a plugin that prints a greeting",
+		BuildMode:   BuildModeBoth,
+		Requires:    []string{PluginModulePath},
+	},
+	"tui": {
+		Description: "an interactive terminal UI built with Bubble Tea and Lip Gloss",
+		BuildMode:   BuildModeBoth,
+		Requires:    []string{PluginModulePath, "github.com/charmbracelet/bubbletea", "github.com/charmbracelet/lipgloss"},
+	},
+	"scanner": {
+		Description: "a plugin that scans a directory for Git repositories",
+		BuildMode:   BuildModeBoth,
+		Requires:    []string{PluginModulePath},
+	},
+	"rpc": {
+		Description: "an out-of-process plugin served over stdio",
+		BuildMode:   BuildModeBinary,
+		Requires:    []string{PluginModulePath},
+	},
+}
+
+// fallbackVersions are used for modules that neither canonical-deps.json nor Gitspace's
+// go.mod pin, for example when scaffolding offline without a cached gitspace-go.mod
+var fallbackVersions = map[string]string{
+	PluginModulePath:                     MinPluginModuleVersion,
+	"github.com/charmbracelet/bubbletea": "v1.1.0",
+	"github.com/charmbracelet/lipgloss":  "v0.13.0",
+}
+
+// TemplateNames returns the names of the templates available to Scaffold
+func TemplateNames() []string {
+	names := make([]string, 0, len(scaffoldTemplates))
+	for name := range scaffoldTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TemplateDescription returns a one-line description of the named template
+func TemplateDescription(name string) string {
+	return scaffoldTemplates[name].Description
+}
+
+// ScaffoldOptions describes a new plugin project
+type ScaffoldOptions struct {
+	// Name is the plugin name, which must be a valid manifest name
+	Name string
+	// Dir is the directory to create. Defaults to Name in the current directory.
+	Dir string
+	// Template is one of TemplateNames. Defaults to DefaultTemplate.
+	Template string
+	// ModulePath is the Go module path. Defaults to Name.
+	ModulePath  string
+	Author      string
+	Description string
+}
+
+// scaffoldData is the data the project templates are executed with
+type scaffoldData struct {
+	ScaffoldOptions
+	// Title is the menu title, derived from Name
+	Title string
+	// TypeName is the Go type implementing the plugin, derived from Name
+	TypeName           string
+	MenuKey            string
+	BuildMode          string
+	PluginAPIVersion   string
+	GitspaceConstraint string
+}
+
+// Scaffold creates a plugin project from a template using the default configuration
+func Scaffold(opts ScaffoldOptions) ([]string, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Scaffold(opts)
+}
+
+// Scaffold creates a new plugin project: main.go, a test, gitspace-plugin.toml, a README
+// and a go.mod whose requirements are pinned to the canonical dependency versions, or to
+// Gitspace's. The directory must not exist or be empty. It returns the created files.
+func (c *Config) Scaffold(opts ScaffoldOptions) ([]string, error) {
+	if !pluginNamePattern.MatchString(opts.Name) {
+		return nil, fmt.Errorf("invalid plugin name %q: use lowercase letters, digits, '.', '_' and '-'", opts.Name)
+	}
+	if opts.Template == "" {
+		opts.Template = DefaultTemplate
+	}
+	tmpl, ok := scaffoldTemplates[opts.Template]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q, expected one of %s", opts.Template, strings.Join(TemplateNames(), ", "))
+	}
+	if opts.Dir == "" {
+		opts.Dir = opts.Name
+	}
+	if opts.ModulePath == "" {
+		opts.ModulePath = opts.Name
+	}
+	if err := module.CheckImportPath(opts.ModulePath); err != nil {
+		return nil, fmt.Errorf("invalid module path: %w", err)
+	}
+	if opts.Description == "" {
+		opts.Description = "A Gitspace plugin"
+	}
+
+	if entries, err := os.ReadDir(opts.Dir); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("%s already exists and is not empty", opts.Dir)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	data := scaffoldData{
+		ScaffoldOptions:  opts,
+		Title:            titleCase(opts.Name),
+		TypeName:         typeName(opts.Name),
+		MenuKey:          opts.Name,
+		BuildMode:        tmpl.BuildMode,
+		PluginAPIVersion: PluginAPIVersion,
+	}
+	for _, reserved := range ReservedMenuKeys {
+		if data.MenuKey == reserved {
+			data.MenuKey += "-plugin"
+		}
+	}
+	if info, err := c.GetVersionInfo(); err == nil && info.GitspaceVersion != "" {
+		data.GitspaceConstraint = ">= " + info.GitspaceVersion
+	}
+
+	files := make(map[string][]byte)
+	for _, dir := range []string{"templates/common", "templates/" + opts.Template} {
+		if err := renderTemplates(dir, data, files); err != nil {
+			return nil, err
+		}
+	}
+
+	goMod, err := c.scaffoldGoMod(opts.ModulePath, tmpl.Requires)
+	if err != nil {
+		return nil, err
+	}
+	files["go.mod"] = goMod
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var created []string
+	for _, name := range names {
+		path := filepath.Join(opts.Dir, name)
+		if err := os.WriteFile(path, files[name], 0644); err != nil {
+			return created, err
+		}
+		created = append(created, path)
+	}
+
+	// A template that produces an invalid manifest is a bug in gsplug
+	if diags := ValidateManifest(files[ManifestFileName], ValidateOptions{Dir: opts.Dir}); HasErrors(diags) {
+		return created, fmt.Errorf("generated manifest is invalid: %s", diags[0])
+	}
+
+	return created, nil
+}
+
+// renderTemplates executes every .tmpl file in dir into files, keyed by output name.
+// gitignore.tmpl becomes .gitignore, since embed skips dot files.
+func renderTemplates(dir string, data scaffoldData, files map[string][]byte) error {
+	entries, err := templateFS.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		src, err := templateFS.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		t, err := template.New(entry.Name()).Funcs(template.FuncMap{"toml": tomlString}).Parse(string(src))
+		if err != nil {
+			return err
+		}
+
+		var out bytes.Buffer
+		if err := t.Execute(&out, data); err != nil {
+			return fmt.Errorf("failed to render %s: %w", entry.Name(), err)
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".tmpl")
+		if name == "gitignore" {
+			name = ".gitignore"
+		}
+		content := out.Bytes()
+		if strings.HasSuffix(name, ".go") {
+			if content, err = format.Source(content); err != nil {
+				return fmt.Errorf("template %s produced invalid Go: %w", entry.Name(), err)
+			}
+		}
+		files[name] = content
+	}
+
+	return nil
+}
+
+// scaffoldGoMod returns a go.mod for modulePath requiring the given modules, sorted by path.
+// Versions come from canonical-deps.json, then Gitspace's go.mod, then fallbackVersions,
+// and PluginModulePath is raised to MinPluginModuleVersion. The go directive is
+// Gitspace's, or the running toolchain's.
+func (c *Config) scaffoldGoMod(modulePath string, requires []string) ([]byte, error) {
+	versions := make(map[string]string)
+	for mod, version := range fallbackVersions {
+		versions[mod] = version
+	}
+	if deps, err := c.GetGitspaceDependencies(); err == nil {
+		for mod, version := range deps {
+			versions[mod] = version
+		}
+	}
+	if canonical, err := c.GetCanonicalDeps(); err == nil {
+		for mod, version := range canonical.Versions {
+			versions[mod] = version
+		}
+	}
+	if semver.Compare(versions[PluginModulePath], MinPluginModuleVersion) < 0 {
+		versions[PluginModulePath] = MinPluginModuleVersion
+	}
+
+	goVersion, err := c.HostGoVersion()
+	if err != nil {
+		goVersion = strings.TrimPrefix(runtime.Version(), "go")
+	}
+
+	f := new(modfile.File)
+	if err := f.AddModuleStmt(modulePath); err != nil {
+		return nil, err
+	}
+	if err := f.AddGoStmt(goVersion); err != nil {
+		return nil, err
+	}
+	requires = slices.Sorted(slices.Values(requires))
+	for _, mod := range requires {
+		if err := f.AddRequire(mod, versions[mod]); err != nil {
+			return nil, err
+		}
+	}
+	f.Cleanup()
+
+	return f.Format()
+}
+
+// titleCase turns a plugin name such as repo-scanner into a title such as Repo Scanner
+func titleCase(name string) string {
+	words := strings.FieldsFunc(name, isNameSeparator)
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// typeName turns a plugin name such as repo-scanner into a Go type name such as RepoScannerPlugin
+func typeName(name string) string {
+	ident := strings.ReplaceAll(titleCase(name), " ", "") + "Plugin"
+	if unicode.IsDigit(rune(ident[0])) {
+		ident = "P" + ident
+	}
+	return ident
+}
+
+func isNameSeparator(r rune) bool {
+	return r == '-' || r == '_' || r == '.'
+}
+
+// tomlString quotes s as a TOML basic string
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
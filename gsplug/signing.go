@@ -0,0 +1,415 @@
+package gsplug
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SignatureExt is appended to an artifact's file name to name its signature file
+const SignatureExt = ".sig"
+
+const (
+	// publicKeyType starts the one-line text form of a public key
+	publicKeyType = "gsplug-ed25519"
+	// signatureAlgorithm is the only algorithm signature files use
+	signatureAlgorithm = "ed25519"
+	// signatureFormatVersion is the version of the signature files written by SignArtifact
+	signatureFormatVersion = 1
+	// signatureContext separates artifact signatures from anything else signed by the same key
+	signatureContext = "gsplug artifact signature v1\n"
+)
+
+// Errors returned by VerifyArtifact, possibly wrapped
+var (
+	// ErrNoSignature means the artifact has no signature file
+	ErrNoSignature = errors.New("artifact is not signed")
+	// ErrUntrustedKey means the artifact is signed by a key that is not in the trusted keys
+	ErrUntrustedKey = errors.New("artifact is signed by an untrusted key")
+	// ErrInvalidSignature means the artifact was modified after it was signed, or the
+	// signature file is corrupt
+	ErrInvalidSignature = errors.New("artifact signature is invalid")
+)
+
+// PublicKey is a public key that signs plugin artifacts. Its text form, used in .pub files
+// and the trusted keys file, is "gsplug-ed25519 <base64 key> [comment]".
+type PublicKey struct {
+	Key ed25519.PublicKey
+	// Comment identifies the key's owner, such as an email address
+	Comment string
+}
+
+// ID returns a short fingerprint of the key, as recorded in signature files
+func (k PublicKey) ID() string {
+	sum := sha256.Sum256(k.Key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// String returns the key's text form
+func (k PublicKey) String() string {
+	s := publicKeyType + " " + base64.StdEncoding.EncodeToString(k.Key)
+	if k.Comment != "" {
+		s += " " + k.Comment
+	}
+	return s
+}
+
+// ParsePublicKey parses the text form of a public key
+func ParsePublicKey(text string) (PublicKey, error) {
+	fields := strings.Fields(text)
+	if len(fields) < 2 || fields[0] != publicKeyType {
+		return PublicKey{}, fmt.Errorf("not a %s public key", publicKeyType)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return PublicKey{}, fmt.Errorf("malformed %s public key", publicKeyType)
+	}
+
+	return PublicKey{Key: key, Comment: strings.Join(fields[2:], " ")}, nil
+}
+
+// Signature is the content of an artifact's signature file
+type Signature struct {
+	FormatVersion int    `json:"format_version"`
+	Algorithm     string `json:"algorithm"`
+	// KeyID is the ID of the public key that verifies the signature
+	KeyID string `json:"key_id"`
+	// SHA256 is the checksum of the signed artifact
+	SHA256 string `json:"sha256"`
+	// Signature signs signatureContext followed by SHA256
+	Signature []byte    `json:"signature"`
+	SignedAt  time.Time `json:"signed_at"`
+}
+
+// signedMessage returns the message signed for an artifact with the given checksum
+func signedMessage(sha256Hex string) []byte {
+	return []byte(signatureContext + sha256Hex + "\n")
+}
+
+// GenerateSigningKey creates a new key pair, writing the private key to path and the
+// public key to path.pub. Existing files are never overwritten.
+func GenerateSigningKey(path, comment string) (PublicKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	key := PublicKey{Key: public, Comment: comment}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return PublicKey{}, err
+	}
+	if err := writeNewFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return PublicKey{}, err
+	}
+	if err := writeNewFile(path+".pub", []byte(key.String()+"\n"), 0644); err != nil {
+		return PublicKey{}, err
+	}
+
+	return key, nil
+}
+
+// writeNewFile writes data to path, failing if the file already exists
+func writeNewFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadSigningKey reads a private key written by GenerateSigningKey
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is a %T, not an ed25519 key", path, key)
+	}
+
+	return private, nil
+}
+
+// SignArtifact signs the artifact at path with key, writing the signature to path.sig.
+// It returns the path of the signature file.
+func SignArtifact(path string, key ed25519.PrivateKey) (string, error) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	public := PublicKey{Key: key.Public().(ed25519.PublicKey)}
+	signature := Signature{
+		FormatVersion: signatureFormatVersion,
+		Algorithm:     signatureAlgorithm,
+		KeyID:         public.ID(),
+		SHA256:        sum,
+		Signature:     ed25519.Sign(key, signedMessage(sum)),
+		SignedAt:      time.Now().UTC(),
+	}
+
+	data, err := json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	sigPath := path + SignatureExt
+	if err := writeFileAtomic(sigPath, append(data, '\n'), 0644); err != nil {
+		return "", err
+	}
+
+	return sigPath, nil
+}
+
+// readSignature reads the signature file of the artifact at path
+func readSignature(path string) (*Signature, error) {
+	data, err := os.ReadFile(path + SignatureExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s not found", ErrNoSignature, filepath.Base(path)+SignatureExt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var signature Signature
+	if err := json.Unmarshal(data, &signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if signature.FormatVersion != signatureFormatVersion || signature.Algorithm != signatureAlgorithm {
+		return nil, fmt.Errorf("%w: unsupported signature format %d (%s)", ErrInvalidSignature, signature.FormatVersion, signature.Algorithm)
+	}
+
+	return &signature, nil
+}
+
+// VerifyArtifact checks the artifact at path against its signature file using the default
+// configuration's trusted keys. A host should call it before plugin.Open.
+func VerifyArtifact(path string) (PublicKey, error) {
+	cfg, err := DefaultConfig()
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return cfg.VerifyArtifact(path)
+}
+
+// VerifyArtifact checks that the artifact at path is signed by a trusted key and has not
+// changed since, and returns the key. The error wraps ErrNoSignature, ErrUntrustedKey or
+// ErrInvalidSignature when verification fails.
+func (c *Config) VerifyArtifact(path string) (PublicKey, error) {
+	keys, err := c.TrustedKeys()
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return VerifyArtifactWithKeys(path, keys)
+}
+
+// VerifyArtifactWithKeys is VerifyArtifact with an explicit list of trusted keys
+func VerifyArtifactWithKeys(path string, keys []PublicKey) (PublicKey, error) {
+	signature, err := readSignature(path)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	var key *PublicKey
+	for i := range keys {
+		if keys[i].ID() == signature.KeyID {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return PublicKey{}, fmt.Errorf("%w %s", ErrUntrustedKey, signature.KeyID)
+	}
+
+	// The checksum in the signature file is not trusted; the artifact is hashed again
+	sum, err := hashFile(path)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	if !ed25519.Verify(key.Key, signedMessage(sum), signature.Signature) {
+		return PublicKey{}, fmt.Errorf("%w: %s does not match its signature by %s", ErrInvalidSignature, filepath.Base(path), key.ID())
+	}
+
+	return *key, nil
+}
+
+// TrustedKeys reads the trusted keys file. Blank lines and lines starting with # are
+// ignored. A missing file trusts no keys.
+func (c *Config) TrustedKeys() ([]PublicKey, error) {
+	data, err := os.ReadFile(c.TrustedKeysPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []PublicKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := ParsePublicKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", c.TrustedKeysPath(), line, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, scanner.Err()
+}
+
+// TrustKey adds key to the trusted keys file unless it is already trusted
+func (c *Config) TrustKey(key PublicKey) error {
+	keys, err := c.TrustedKeys()
+	if err != nil {
+		return err
+	}
+	for _, trusted := range keys {
+		if trusted.ID() == key.ID() {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(c.TrustedKeysPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, key.String()+"\n"...)
+
+	return writeFileAtomic(c.TrustedKeysPath(), data, 0644)
+}
+
+// UntrustKey removes the key with the given ID from the trusted keys file, keeping
+// comments and other keys as they are
+func (c *Config) UntrustKey(id string) error {
+	data, err := os.ReadFile(c.TrustedKeysPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var kept bytes.Buffer
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if key, err := ParsePublicKey(scanner.Text()); err == nil && key.ID() == id {
+			found = true
+			continue
+		}
+		kept.WriteString(scanner.Text() + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("key %s is not trusted", id)
+	}
+
+	return writeFileAtomic(c.TrustedKeysPath(), kept.Bytes(), 0644)
+}
+
+// SignPlugin signs every artifact of the built plugin in pluginDir and returns the
+// signature files
+func SignPlugin(pluginDir string, key ed25519.PrivateKey) ([]string, error) {
+	manifest, err := ReadManifest(filepath.Join(pluginDir, ManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin manifest: %w", err)
+	}
+	artifacts, err := manifest.Artifacts(pluginDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var signatures []string
+	for _, artifact := range artifacts {
+		sigPath, err := SignArtifact(artifact.Path, key)
+		if err != nil {
+			return signatures, fmt.Errorf("failed to sign %s: %w", artifact.Path, err)
+		}
+		signatures = append(signatures, sigPath)
+	}
+
+	return signatures, nil
+}
+
+// VerifyPackage verifies the signatures of the artifacts in the package at path against
+// the trusted keys, and returns the key that signed each artifact. Unsigned artifacts are
+// an error if required is set, and are otherwise left out of the result.
+func (c *Config) VerifyPackage(path string, required bool) (map[string]PublicKey, error) {
+	pkg, err := ReadPackage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "gsplug-verify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := pkg.Extract(dir); err != nil {
+		return nil, err
+	}
+	return c.verifyArtifacts(dir, pkg.Info.Artifacts, required)
+}
+
+// verifyArtifacts verifies the signatures of artifacts, relative to dir, against the
+// trusted keys. Unsigned artifacts are an error if required is set.
+func (c *Config) verifyArtifacts(dir string, artifacts []PackageArtifact, required bool) (map[string]PublicKey, error) {
+	keys, err := c.TrustedKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make(map[string]PublicKey)
+	for _, artifact := range artifacts {
+		key, err := VerifyArtifactWithKeys(filepath.Join(dir, artifact.Path), keys)
+		if errors.Is(err, ErrNoSignature) && !required {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", artifact.Path, err)
+		}
+		signers[artifact.Path] = key
+	}
+
+	return signers, nil
+}
@@ -0,0 +1,128 @@
+package gsplug
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ToolchainPolicy decides what happens when the Go toolchain used to build a Go plugin
+// differs from the one Gitspace is built with
+type ToolchainPolicy string
+
+const (
+	// ToolchainFail refuses to build with a mismatched toolchain
+	ToolchainFail ToolchainPolicy = "fail"
+	// ToolchainAuto sets GOTOOLCHAIN so that the go command switches to the host's
+	// toolchain, downloading it if needed
+	ToolchainAuto ToolchainPolicy = "auto"
+	// ToolchainIgnore builds with whatever toolchain the runner provides
+	ToolchainIgnore ToolchainPolicy = "ignore"
+)
+
+// ParseToolchainPolicy parses the name of a ToolchainPolicy; "" is ToolchainFail
+func ParseToolchainPolicy(name string) (ToolchainPolicy, error) {
+	switch policy := ToolchainPolicy(name); policy {
+	case "":
+		return ToolchainFail, nil
+	case ToolchainFail, ToolchainAuto, ToolchainIgnore:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown toolchain policy %q, expected %s, %s or %s", name, ToolchainFail, ToolchainAuto, ToolchainIgnore)
+	}
+}
+
+// ToolchainMismatchError is returned when a Go plugin would be built with a toolchain
+// other than the host's. Such a plugin fails to load with "plugin was built with a
+// different version of package runtime".
+type ToolchainMismatchError struct {
+	// Required is the host's Go version, Actual the GOVERSION of the build toolchain
+	Required string
+	Actual   string
+}
+
+func (e *ToolchainMismatchError) Error() string {
+	return fmt.Sprintf("Go plugins must be built with the Gitspace host's toolchain go%s, but the build would use %s; "+
+		"install go%s, or build with -toolchain auto or -hermetic", e.Required, e.Actual, e.Required)
+}
+
+// selectToolchain checks the toolchain goCmd runs with against the host's Go version and
+// applies policy. It returns the build environment to use, which sets GOTOOLCHAIN if the
+// policy switched toolchains, and the host toolchain as a GOVERSION such as go1.23.1.
+func (c *Config) selectToolchain(ctx context.Context, runner BuildRunner, goCmd GoCommand, policy ToolchainPolicy) ([]string, string, error) {
+	if policy == ToolchainIgnore {
+		required, err := c.HostGoVersion()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to determine the Gitspace Go version: %w", err)
+		}
+		return goCmd.Env, "go" + required, nil
+	}
+	required, err := c.pluginGoVersion()
+	if err != nil {
+		return nil, "", err
+	}
+
+	actual, err := goVersion(ctx, runner, goCmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.TrimPrefix(actual, "go") == required {
+		return goCmd.Env, "go" + required, nil
+	}
+	if policy != ToolchainAuto {
+		return nil, "", &ToolchainMismatchError{Required: required, Actual: actual}
+	}
+
+	goCmd.Env = append(goCmd.Env[:len(goCmd.Env):len(goCmd.Env)], "GOTOOLCHAIN="+toolchainName(required))
+	switched, err := goVersion(ctx, runner, goCmd)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to switch to toolchain %s: %w", toolchainName(required), err)
+	}
+	if strings.TrimPrefix(switched, "go") != required {
+		return nil, "", &ToolchainMismatchError{Required: required, Actual: switched}
+	}
+
+	return goCmd.Env, "go" + required, nil
+}
+
+// languageVersion matches Go versions that name a language version rather than a release
+var languageVersion = regexp.MustCompile(`^\d+\.\d+$`)
+
+// pluginGoVersion returns the Go release Gitspace is built with, without the "go" prefix.
+// Go plugins must be built with exactly that release, since the runtime refuses to open
+// plugins built by any other. It fails if gitspace-go.mod only names a language version
+// such as 1.23, which does not say which release that is.
+func (c *Config) pluginGoVersion() (string, error) {
+	version, err := c.HostGoVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the Gitspace Go version: %w", err)
+	}
+	if languageVersion.MatchString(version) {
+		return "", fmt.Errorf("%s names Go %s but not the release Gitspace is built with, which Go plugins must match exactly; "+
+			"add a toolchain directive such as `toolchain go%s.0` to it", c.GitspaceModPath(), version, version)
+	}
+	return version, nil
+}
+
+// goVersion returns the GOVERSION of the toolchain the runner uses for goCmd
+func goVersion(ctx context.Context, runner BuildRunner, goCmd GoCommand) (string, error) {
+	goEnv, err := goEnvironment(ctx, runner, goCmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(goEnv, "\n", 2)[0], nil
+}
+
+// toolchainName returns the toolchain release name for a go.mod version. From Go 1.21 on,
+// the first release of 1.N is go1.N.0, while the language version is written 1.N.
+func toolchainName(version string) string {
+	parts := strings.Split(version, ".")
+	if len(parts) == 2 {
+		if minor, err := strconv.Atoi(parts[1]); err == nil && minor >= 21 {
+			version += ".0"
+		}
+	}
+	return "go" + version
+}
@@ -0,0 +1,41 @@
+package gsplug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckBuiltGoPluginToolchain(t *testing.T) {
+	tests := []struct {
+		name      string
+		gitspace  string
+		toolchain string
+		goPlugin  bool
+		// wantErr is a part of the expected error, or empty if none is expected
+		wantErr string
+	}{
+		{name: "same release", gitspace: "go 1.23.1\n", toolchain: "go1.23.1", goPlugin: true},
+		{name: "other patch release", gitspace: "go 1.23.1\n", toolchain: "go1.23.4", goPlugin: true, wantErr: "will not load"},
+		{name: "toolchain directive", gitspace: "go 1.23\n\ntoolchain go1.23.4\n", toolchain: "go1.23.4", goPlugin: true},
+		{name: "language version only", gitspace: "go 1.23\n", toolchain: "go1.23.4", goPlugin: true, wantErr: "must match exactly"},
+		{name: "binaries are not checked", gitspace: "go 1.23\n", toolchain: "go1.22.0"},
+		{name: "unknown toolchain", gitspace: "go 1.23\n", goPlugin: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			writeTestFile(t, cfg.GitspaceModPath(), "module github.com/ssotops/gitspace\n\n"+tt.gitspace)
+
+			err := cfg.checkBuilt(&PluginManifest{}, "", tt.toolchain, tt.goPlugin)
+			switch {
+			case tt.wantErr == "":
+				if err != nil {
+					t.Errorf("checkBuilt() = %v, want nil", err)
+				}
+			case err == nil || !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("checkBuilt() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,25 +1,70 @@
 package gsplug
 
 type VersionInfo struct {
-	GitspaceVersion string `json:"gitspace_version"`
+	GitspaceVersion  string `json:"gitspace_version"`
 	PluginAPIVersion string `json:"plugin_api_version"`
 }
 type PluginManifest struct {
-
 	Metadata struct {
 		Name        string `toml:"name"`
 		Version     string `toml:"version"`
 		Description string `toml:"description"`
 		Author      string `toml:"author"`
 	} `toml:"metadata"`
+	// Compatibility holds semver constraints on the host. An empty constraint
+	// matches any version.
+	Compatibility struct {
+		Gitspace  string `toml:"gitspace"`
+		PluginAPI string `toml:"plugin_api"`
+	} `toml:"compatibility"`
 	Menu struct {
 		Title string `toml:"title"`
 		Key   string `toml:"key"`
+		// Icon, Hotkey and Weight are copied to the plugin's menu item, see MenuItem
+		Icon   string `toml:"icon,omitempty"`
+		Hotkey string `toml:"hotkey,omitempty"`
+		Weight int    `toml:"weight,omitempty"`
 	} `toml:"menu"`
 	Sources []struct {
 		Path       string `toml:"path"`
 		EntryPoint string `toml:"entry_point"`
 	} `toml:"sources"`
+	Build   BuildConfig   `toml:"build"`
+	Runtime RuntimeConfig `toml:"runtime,omitempty"`
+	// Config declares the plugin's settings, see PluginConfig
+	Config []ConfigField `toml:"config,omitempty"`
+}
+
+// RuntimeConfig is the [runtime] table of a plugin manifest. Its timeouts are durations
+// such as "30s" or "5m"; "0" removes a limit. See Timeouts for the defaults.
+type RuntimeConfig struct {
+	InitTimeout     string `toml:"init_timeout,omitempty"`
+	RunTimeout      string `toml:"run_timeout,omitempty"`
+	ShutdownTimeout string `toml:"shutdown_timeout,omitempty"`
+}
+
+// Build modes accepted by the mode key of the [build] table
+const (
+	BuildModePlugin = "plugin"
+	BuildModeBinary = "binary"
+	BuildModeBoth   = "both"
+)
+
+// BuildConfig is the [build] table of a plugin manifest
+type BuildConfig struct {
+	// Mode is one of plugin, binary or both. When empty it is inferred from
+	// which of Binary and Plugin are set, defaulting to plugin.
+	Mode string `toml:"mode,omitempty"`
+	// Binary and Plugin are output paths relative to the plugin directory
+	Binary   string   `toml:"binary,omitempty"`
+	Plugin   string   `toml:"plugin,omitempty"`
+	LDFlags  string   `toml:"ldflags,omitempty"`
+	Tags     []string `toml:"tags,omitempty"`
+	Trimpath bool     `toml:"trimpath,omitempty"`
+	// Reproducible always builds in reproducible mode, see BuildOptions.Reproducible
+	Reproducible bool              `toml:"reproducible,omitempty"`
+	CGO          *bool             `toml:"cgo,omitempty"`
+	Env          map[string]string `toml:"env,omitempty"`
 }
 
 type Option struct {
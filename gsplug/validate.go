@@ -0,0 +1,440 @@
+package gsplug
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// Severity is the severity of a manifest diagnostic
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic describes a single problem found in a plugin manifest
+type Diagnostic struct {
+	// Field is the dotted path of the offending key, e.g. sources[0].entry_point
+	Field string
+	// Line and Column are 1-based positions in the manifest
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Field == "" {
+		return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s: %s", d.Line, d.Column, d.Severity, d.Field, d.Message)
+}
+
+// ValidateOptions controls ValidateManifest
+type ValidateOptions struct {
+	// Strict reports unknown keys as errors instead of warnings
+	Strict bool
+	// Dir is the plugin directory. When set, source paths are checked to exist.
+	Dir string
+}
+
+// ReservedMenuKeys are the menu keys used by Gitspace's built-in menu entries
+var ReservedMenuKeys = []string{"back", "clone", "config", "help", "plugins", "quit", "sync", "update"}
+
+var (
+	pluginNamePattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+	configNamePattern   = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	hotkeyPattern       = regexp.MustCompile(`^((ctrl|alt)\+)?[a-z0-9]$|^f([1-9]|1[0-2])$`)
+	typeMismatchPattern = regexp.MustCompile(`^cannot decode TOML (\w+) into .* of type (.+)$`)
+)
+
+// HasErrors reports whether any diagnostic has error severity
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateManifestFile reads and validates the manifest at path.
+// If opts.Dir is empty, the manifest's directory is used.
+func ValidateManifestFile(path string, opts ValidateOptions) ([]Diagnostic, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Dir == "" {
+		opts.Dir = filepath.Dir(path)
+	}
+
+	return ValidateManifest(data, opts), nil
+}
+
+// ValidateManifest checks a manifest document and returns its diagnostics ordered by position
+func ValidateManifest(data []byte, opts ValidateOptions) []Diagnostic {
+	v := &manifestValidator{opts: opts}
+
+	positions, err := keyPositions(data)
+	if err != nil {
+		v.addDecodeError(err)
+		return v.diags
+	}
+	v.positions = positions
+
+	var manifest PluginManifest
+	if err := toml.Unmarshal(data, &manifest); err != nil {
+		v.addDecodeError(err)
+		return v.sorted()
+	}
+
+	v.checkUnknownKeys(data)
+	v.checkManifest(&manifest)
+
+	return v.sorted()
+}
+
+type manifestValidator struct {
+	opts      ValidateOptions
+	positions map[string]unstable.Position
+	diags     []Diagnostic
+}
+
+func (v *manifestValidator) add(severity Severity, field, format string, args ...interface{}) {
+	pos := v.position(field)
+	v.diags = append(v.diags, Diagnostic{
+		Field:    field,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// position returns the position of field, falling back to its closest enclosing table
+func (v *manifestValidator) position(field string) unstable.Position {
+	for field != "" {
+		if pos, ok := v.positions[field]; ok {
+			return pos
+		}
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return unstable.Position{Line: 1, Column: 1}
+}
+
+func (v *manifestValidator) addDecodeError(err error) {
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, column := decodeErr.Position()
+		message := strings.TrimPrefix(decodeErr.Error(), "toml: ")
+		if m := typeMismatchPattern.FindStringSubmatch(message); m != nil {
+			message = fmt.Sprintf("expected %s, found TOML %s", m[2], m[1])
+		}
+		v.diags = append(v.diags, Diagnostic{
+			Field:    v.fieldAt(line, column),
+			Line:     line,
+			Column:   column,
+			Severity: SeverityError,
+			Message:  message,
+		})
+		return
+	}
+
+	v.diags = append(v.diags, Diagnostic{Line: 1, Column: 1, Severity: SeverityError, Message: err.Error()})
+}
+
+// fieldAt returns the key defined closest before the given position on the same line
+func (v *manifestValidator) fieldAt(line, column int) string {
+	field, best := "", 0
+	for key, pos := range v.positions {
+		if pos.Line == line && pos.Column <= column && pos.Column > best {
+			field, best = key, pos.Column
+		}
+	}
+	return field
+}
+
+func (v *manifestValidator) checkUnknownKeys(data []byte) {
+	decoder := toml.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var manifest PluginManifest
+	err := decoder.Decode(&manifest)
+
+	var strictErr *toml.StrictMissingError
+	if !errors.As(err, &strictErr) {
+		return
+	}
+
+	severity := SeverityWarning
+	if v.opts.Strict {
+		severity = SeverityError
+	}
+	for _, e := range strictErr.Errors {
+		line, column := e.Position()
+		v.diags = append(v.diags, Diagnostic{
+			Field:    strings.Join(e.Key(), "."),
+			Line:     line,
+			Column:   column,
+			Severity: severity,
+			Message:  "unknown key",
+		})
+	}
+}
+
+func (v *manifestValidator) checkManifest(m *PluginManifest) {
+	name := m.Metadata.Name
+	switch {
+	case name == "":
+		v.add(SeverityError, "metadata.name", "name is required")
+	case !pluginNamePattern.MatchString(name):
+		v.add(SeverityError, "metadata.name", "name %q must be lowercase letters, digits, '.', '_' or '-'", name)
+	}
+
+	if m.Metadata.Version == "" {
+		v.add(SeverityError, "metadata.version", "version is required")
+	} else if _, err := semver.StrictNewVersion(m.Metadata.Version); err != nil {
+		v.add(SeverityError, "metadata.version", "version %q is not a valid semantic version", m.Metadata.Version)
+	}
+
+	if m.Metadata.Description == "" {
+		v.add(SeverityWarning, "metadata.description", "description is empty")
+	}
+
+	for field, constraint := range map[string]string{
+		"compatibility.gitspace":   m.Compatibility.Gitspace,
+		"compatibility.plugin_api": m.Compatibility.PluginAPI,
+	} {
+		if constraint == "" {
+			continue
+		}
+		if _, err := semver.NewConstraint(constraint); err != nil {
+			v.add(SeverityError, field, "constraint %q is not a valid semver constraint", constraint)
+		}
+	}
+	if m.Compatibility.PluginAPI == "" {
+		v.add(SeverityWarning, "compatibility.plugin_api", "no plugin_api constraint; the plugin will be accepted by any plugin API version")
+	}
+
+	if m.Menu.Key == "" {
+		v.add(SeverityWarning, "menu.key", "menu key is empty; the plugin will not appear in the Gitspace menu")
+	} else {
+		for _, reserved := range ReservedMenuKeys {
+			if strings.EqualFold(m.Menu.Key, reserved) {
+				v.add(SeverityError, "menu.key", "menu key %q collides with a built-in Gitspace menu entry", m.Menu.Key)
+				break
+			}
+		}
+	}
+	if m.Menu.Key != "" && m.Menu.Title == "" {
+		v.add(SeverityWarning, "menu.title", "menu title is empty")
+	}
+	if m.Menu.Hotkey != "" && !hotkeyPattern.MatchString(m.Menu.Hotkey) {
+		v.add(SeverityError, "menu.hotkey", "hotkey %q must be a lowercase letter or digit, optionally prefixed with ctrl+ or alt+, or f1 to f12", m.Menu.Hotkey)
+	}
+
+	if len(m.Sources) == 0 {
+		v.add(SeverityError, "sources", "at least one [[sources]] entry is required")
+	}
+	for i, source := range m.Sources {
+		field := "sources[" + strconv.Itoa(i) + "]"
+
+		if source.Path == "" {
+			v.add(SeverityError, field+".path", "path is required")
+		} else if v.opts.Dir != "" {
+			if _, err := os.Stat(filepath.Join(v.opts.Dir, source.Path)); err != nil {
+				v.add(SeverityError, field+".path", "source %s does not exist", source.Path)
+			}
+		}
+
+		switch {
+		case source.EntryPoint == "":
+			v.add(SeverityError, field+".entry_point", "entry_point is required")
+		case !token.IsIdentifier(source.EntryPoint) || !token.IsExported(source.EntryPoint):
+			v.add(SeverityError, field+".entry_point", "entry_point %q must be an exported Go identifier", source.EntryPoint)
+		}
+	}
+
+	for field, value := range map[string]string{
+		"runtime.init_timeout":     m.Runtime.InitTimeout,
+		"runtime.run_timeout":      m.Runtime.RunTimeout,
+		"runtime.shutdown_timeout": m.Runtime.ShutdownTimeout,
+	} {
+		if value == "" {
+			continue
+		}
+		if timeout, err := time.ParseDuration(value); err != nil || timeout < 0 {
+			v.add(SeverityError, field, "timeout %q must be a non-negative duration such as \"30s\" or \"5m\"", value)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, field := range m.Config {
+		prefix := "config[" + strconv.Itoa(i) + "]"
+
+		switch {
+		case field.Name == "":
+			v.add(SeverityError, prefix+".name", "name is required")
+		case !configNamePattern.MatchString(field.Name):
+			v.add(SeverityError, prefix+".name", "name %q must be lowercase letters, digits or '_', starting with a letter", field.Name)
+		case seen[field.Name]:
+			v.add(SeverityError, prefix+".name", "setting %q is declared more than once", field.Name)
+		}
+		seen[field.Name] = true
+
+		switch field.EffectiveType() {
+		case ConfigTypeString, ConfigTypeInt, ConfigTypeFloat, ConfigTypeBool, ConfigTypeDuration, ConfigTypeList:
+		default:
+			v.add(SeverityError, prefix+".type", "type %q must be one of string, int, float, bool, duration or list", field.Type)
+			continue
+		}
+		if _, err := field.enumValues(); err != nil {
+			v.add(SeverityError, prefix+".enum", "%v", err)
+			continue
+		}
+		if field.Default != nil {
+			if _, err := field.coerce(field.Default); err != nil {
+				v.add(SeverityError, prefix+".default", "%v", err)
+			} else if field.Required {
+				v.add(SeverityWarning, prefix+".required", "setting %q has a default, so it is never missing", field.Name)
+			}
+		}
+	}
+
+	mode, err := m.Build.EffectiveMode()
+	if err != nil {
+		v.add(SeverityError, "build.mode", "%v", err)
+	} else if m.Build.CGO != nil && !*m.Build.CGO && mode != BuildModeBinary {
+		v.add(SeverityError, "build.cgo", "cgo cannot be disabled when building a Go plugin (mode %s)", mode)
+	}
+	if err == nil && mode != BuildModeBinary && v.opts.Dir != "" && len(m.Sources) > 0 {
+		if declared, err := declaresAPIVersion(v.opts.Dir, m.Build.Tags); err == nil && !declared {
+			v.add(SeverityWarning, "sources", "%v; the build will fail", missingAPIVersionError())
+		}
+	}
+}
+
+func (v *manifestValidator) sorted() []Diagnostic {
+	sort.SliceStable(v.diags, func(i, j int) bool {
+		if v.diags[i].Line != v.diags[j].Line {
+			return v.diags[i].Line < v.diags[j].Line
+		}
+		return v.diags[i].Column < v.diags[j].Column
+	})
+	return v.diags
+}
+
+// keyPositions maps every key in a TOML document to the position where it is defined.
+// Entries of arrays of tables are indexed, e.g. sources[1].path.
+func keyPositions(data []byte) (map[string]unstable.Position, error) {
+	positions := make(map[string]unstable.Position)
+	arrayCounts := make(map[string]int)
+
+	var p unstable.Parser
+	p.Reset(data)
+
+	prefix := ""
+	for p.NextExpression() {
+		expr := p.Expression()
+
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			key, pos := nodeKey(&p, expr)
+			if expr.Kind == unstable.ArrayTable {
+				index := arrayCounts[key]
+				arrayCounts[key]++
+				if _, ok := positions[key]; !ok {
+					positions[key] = pos
+				}
+				key += "[" + strconv.Itoa(index) + "]"
+			}
+			positions[key] = pos
+			prefix = key + "."
+		case unstable.KeyValue:
+			key, pos := nodeKey(&p, expr)
+			positions[prefix+key] = pos
+			if value := expr.Value(); value.Kind == unstable.InlineTable {
+				inlineKeyPositions(&p, value, prefix+key+".", positions)
+			}
+		}
+	}
+
+	if err := p.Error(); err != nil {
+		return nil, toDecodeError(data, err)
+	}
+	return positions, nil
+}
+
+// inlineKeyPositions records the keys of an inline table value
+func inlineKeyPositions(p *unstable.Parser, table *unstable.Node, prefix string, positions map[string]unstable.Position) {
+	it := table.Children()
+	for it.Next() {
+		kv := it.Node()
+		if kv.Kind != unstable.KeyValue {
+			continue
+		}
+		key, pos := nodeKey(p, kv)
+		positions[prefix+key] = pos
+		if value := kv.Value(); value.Kind == unstable.InlineTable {
+			inlineKeyPositions(p, value, prefix+key+".", positions)
+		}
+	}
+}
+
+// nodeKey returns the dotted key of a table or key/value node and the position of its first part
+func nodeKey(p *unstable.Parser, node *unstable.Node) (string, unstable.Position) {
+	var parts []string
+	var pos unstable.Position
+
+	it := node.Key()
+	for it.Next() {
+		part := it.Node()
+		if len(parts) == 0 {
+			pos = p.Shape(part.Raw).Start
+		}
+		parts = append(parts, string(part.Data))
+	}
+
+	return strings.Join(parts, "."), pos
+}
+
+// toDecodeError converts a raw parser error into a positioned toml.DecodeError
+func toDecodeError(data []byte, err error) error {
+	// Decoding the document again reports the same syntax error with its position
+	var discard map[string]interface{}
+	if decodeErr := toml.Unmarshal(data, &discard); decodeErr != nil {
+		return decodeErr
+	}
+	return err
+}
@@ -0,0 +1,234 @@
+package gsplug
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const validManifest = `[metadata]
+name = "hello"
+version = "1.0.0"
+description = "Says hello"
+
+[compatibility]
+gitspace = ">= 0.5.0"
+plugin_api = "^1.0"
+
+[menu]
+key = "hello"
+title = "Hello"
+
+[[sources]]
+path = "."
+entry_point = "Plugin"
+`
+
+// replaceLine replaces the line old of doc, which must be present, with new
+func replaceLine(t *testing.T, doc, old, new string) string {
+	t.Helper()
+	if !strings.Contains(doc, old+"\n") {
+		t.Fatalf("line %q not found", old)
+	}
+	return strings.Replace(doc, old+"\n", new+"\n", 1)
+}
+
+// diagnosticStrings formats diagnostics as ValidateManifest prints them
+func diagnosticStrings(diags []Diagnostic) []string {
+	var out []string
+	for _, d := range diags {
+		out = append(out, d.String())
+	}
+	return out
+}
+
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		opts ValidateOptions
+		want []string
+	}{
+		{
+			name: "valid",
+			doc:  validManifest,
+		},
+		{
+			name: "missing and malformed metadata",
+			doc: `[metadata]
+name = "Hello World"
+version = "1.0"
+
+[[sources]]
+path = "."
+`,
+			want: []string{
+				"1:1: warning: compatibility.plugin_api: no plugin_api constraint; the plugin will be accepted by any plugin API version",
+				"1:1: warning: menu.key: menu key is empty; the plugin will not appear in the Gitspace menu",
+				"1:2: warning: metadata.description: description is empty",
+				`2:1: error: metadata.name: name "Hello World" must be lowercase letters, digits, '.', '_' or '-'`,
+				`3:1: error: metadata.version: version "1.0" is not a valid semantic version`,
+				"5:3: error: sources[0].entry_point: entry_point is required",
+			},
+		},
+		{
+			name: "reserved menu key and invalid hotkey",
+			doc:  replaceLine(t, validManifest, `key = "hello"`, "key = \"Config\"\nhotkey = \"G\""),
+			want: []string{
+				`11:1: error: menu.key: menu key "Config" collides with a built-in Gitspace menu entry`,
+				`12:1: error: menu.hotkey: hotkey "G" must be a lowercase letter or digit, optionally prefixed with ctrl+ or alt+, or f1 to f12`,
+			},
+		},
+		{
+			name: "unknown key",
+			doc:  replaceLine(t, validManifest, `title = "Hello"`, "title = \"Hello\"\ncolour = \"red\""),
+			want: []string{"13:1: warning: menu.colour: unknown key"},
+		},
+		{
+			name: "unknown key in strict mode",
+			doc:  replaceLine(t, validManifest, `title = "Hello"`, "title = \"Hello\"\ncolour = \"red\""),
+			opts: ValidateOptions{Strict: true},
+			want: []string{"13:1: error: menu.colour: unknown key"},
+		},
+		{
+			name: "type mismatch",
+			doc:  replaceLine(t, validManifest, `version = "1.0.0"`, `version = 1`),
+			want: []string{"3:11: error: metadata.version: expected string, found TOML integer"},
+		},
+		{
+			name: "syntax error",
+			doc:  replaceLine(t, validManifest, `version = "1.0.0"`, `version = "1.0.0`),
+			want: []string{"3:17: error: basic strings cannot have new lines"},
+		},
+		{
+			name: "entry point and build mode",
+			doc:  replaceLine(t, validManifest, `entry_point = "Plugin"`, `entry_point = "plugin"`) + "\n[build]\nmode = \"library\"\n",
+			want: []string{
+				`16:1: error: sources[0].entry_point: entry_point "plugin" must be an exported Go identifier`,
+				`19:1: error: build.mode: unknown build mode "library", expected plugin, binary or both`,
+			},
+		},
+		{
+			name: "cgo disabled for a Go plugin",
+			doc:  validManifest + "\n[build]\ncgo = false\n",
+			want: []string{"19:1: error: build.cgo: cgo cannot be disabled when building a Go plugin (mode plugin)"},
+		},
+		{
+			name: "runtime timeouts and config schema",
+			doc: validManifest + `
+[runtime]
+run_timeout = "-1s"
+init_timeout = "soon"
+
+[[config]]
+name = "retries"
+type = "int"
+default = "three"
+
+[[config]]
+name = "retries"
+type = "map"
+
+[[config]]
+name = "Mode"
+enum = [1]
+
+[[config]]
+name = "scopes"
+type = "list"
+enum = ["read", "write"]
+default = ["read", "admin"]
+
+[[config]]
+name = "token"
+required = true
+default = "x"
+`,
+			want: []string{
+				`19:1: error: runtime.run_timeout: timeout "-1s" must be a non-negative duration such as "30s" or "5m"`,
+				`20:1: error: runtime.init_timeout: timeout "soon" must be a non-negative duration such as "30s" or "5m"`,
+				"25:1: error: config[0].default: expected int, found string",
+				`28:1: error: config[1].name: setting "retries" is declared more than once`,
+				`29:1: error: config[1].type: type "map" must be one of string, int, float, bool, duration or list`,
+				`32:1: error: config[2].name: name "Mode" must be lowercase letters, digits or '_', starting with a letter`,
+				"33:1: error: config[2].enum: enum value 1: expected string, found int",
+				`39:1: error: config[3].default: "admin" is not one of ["read", "write"]`,
+				`43:1: warning: config[4].required: setting "token" has a default, so it is never missing`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diagnosticStrings(ValidateManifest([]byte(tt.doc), tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateManifestSources(t *testing.T) {
+	const declared = "package main\n\nvar GitspacePluginAPIVersion = \"1.1.0\"\n"
+	const undeclared = "package main\n"
+	missingSymbol := "14:3: warning: sources: " + missingAPIVersionError().Error() + "; the build will fail"
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		doc   string
+		want  []string
+	}{
+		{
+			name:  "declares the API version",
+			files: map[string]string{"main.go": declared},
+			doc:   validManifest,
+		},
+		{
+			name:  "does not declare the API version",
+			files: map[string]string{"main.go": undeclared, "main_test.go": declared},
+			doc:   validManifest,
+			want:  []string{missingSymbol},
+		},
+		{
+			name:  "declares the API version in a file excluded by build tags",
+			files: map[string]string{"main.go": undeclared, "version.go": "//go:build gitspace\n\n" + declared},
+			doc:   validManifest,
+			want:  []string{missingSymbol},
+		},
+		{
+			name:  "declares the API version in a file selected by build tags",
+			files: map[string]string{"main.go": undeclared, "version.go": "//go:build gitspace\n\n" + declared},
+			doc:   validManifest + "\n[build]\ntags = [\"gitspace\"]\n",
+		},
+		{
+			name:  "binaries need not declare the API version",
+			files: map[string]string{"main.go": undeclared},
+			doc:   validManifest + "\n[build]\nmode = \"binary\"\n",
+		},
+		{
+			name:  "missing source",
+			files: map[string]string{"main.go": declared},
+			doc:   replaceLine(t, validManifest, `path = "."`, `path = "cmd/hello"`),
+			want:  []string{"15:1: error: sources[0].path: source cmd/hello does not exist"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := diagnosticStrings(ValidateManifest([]byte(tt.doc), ValidateOptions{Dir: dir}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}